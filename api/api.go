@@ -0,0 +1,198 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const interactionsPath = "/interactions"
+
+// maxLimit is the maximum amount of interactions returned by a single list request.
+const maxLimit = 1000
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type deleteResponse struct {
+	Deleted int `json:"deleted"`
+}
+
+// Handler serves the stored interactions as JSON:
+//
+//	GET    /interactions       lists the interactions matching the query parameters
+//	DELETE /interactions       deletes the interactions matching the query parameters
+//	GET    /interactions/<id>  returns a single interaction
+//	DELETE /interactions/<id>  deletes a single interaction
+//
+// The query parameters are type, token, host, remote_address, owner,
+// connection_id, since, until (RFC 3339), after_id and limit. Users which are not administrators
+// can only access their own interactions.
+type Handler struct {
+	store ohren.Store
+}
+
+func NewHandler(store ohren.Store) *Handler {
+	return &Handler{
+		store: store,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if auth.UserFromRequest(r) == nil {
+		writeError(w, http.StatusUnauthorized, "unauthorized")
+		return
+	}
+	if r.URL.Path == interactionsPath || r.URL.Path == interactionsPath+"/" {
+		h.serveInteractions(w, r)
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, interactionsPath+"/") {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	id, err := strconv.ParseUint(strings.TrimPrefix(r.URL.Path, interactionsPath+"/"), 10, 64)
+	if err != nil || id == 0 {
+		writeError(w, http.StatusNotFound, "invalid interaction id")
+		return
+	}
+	h.serveInteraction(w, r, id)
+}
+
+func (h *Handler) serveInteractions(w http.ResponseWriter, r *http.Request) {
+	query, err := ParseQuery(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	restrictOwner(r, &query)
+	switch r.Method {
+	case http.MethodGet:
+		if query.Limit <= 0 || query.Limit > maxLimit {
+			query.Limit = maxLimit
+		}
+		interactions, err := h.store.Find(query)
+		if err != nil {
+			log.Printf("failed to find interactions: %s", err)
+			writeError(w, http.StatusInternalServerError, "failed to find interactions")
+			return
+		}
+		if interactions == nil {
+			interactions = []*ohren.Interaction{}
+		}
+		writeJSON(w, http.StatusOK, interactions)
+	case http.MethodDelete:
+		deleted, err := h.store.Delete(query)
+		if err != nil {
+			log.Printf("failed to delete interactions: %s", err)
+			writeError(w, http.StatusInternalServerError, "failed to delete interactions")
+			return
+		}
+		writeJSON(w, http.StatusOK, &deleteResponse{Deleted: deleted})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func (h *Handler) serveInteraction(w http.ResponseWriter, r *http.Request, id uint64) {
+	query := ohren.Query{ID: id}
+	restrictOwner(r, &query)
+	switch r.Method {
+	case http.MethodGet:
+		interactions, err := h.store.Find(query)
+		if err != nil {
+			log.Printf("failed to find interaction: %s", err)
+			writeError(w, http.StatusInternalServerError, "failed to find interaction")
+			return
+		}
+		if len(interactions) == 0 {
+			writeError(w, http.StatusNotFound, "interaction not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, interactions[0])
+	case http.MethodDelete:
+		deleted, err := h.store.Delete(query)
+		if err != nil {
+			log.Printf("failed to delete interaction: %s", err)
+			writeError(w, http.StatusInternalServerError, "failed to delete interaction")
+			return
+		}
+		if deleted == 0 {
+			writeError(w, http.StatusNotFound, "interaction not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, &deleteResponse{Deleted: deleted})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// restrictOwner limits the query to the interactions of the user.
+func restrictOwner(r *http.Request, query *ohren.Query) {
+	if owner := auth.UserFromRequest(r).Owner(); owner != "" {
+		query.Owner = owner
+	}
+}
+
+// ParseQuery converts the URL query parameters to a store query.
+func ParseQuery(values url.Values) (query ohren.Query, err error) {
+	query.Type = values.Get("type")
+	query.Token = values.Get("token")
+	query.Host = values.Get("host")
+	query.RemoteAddress = values.Get("remote_address")
+	query.Owner = values.Get("owner")
+	query.ConnectionID = values.Get("connection_id")
+	if query.Since, err = parseTime(values, "since"); err != nil {
+		return
+	}
+	if query.Until, err = parseTime(values, "until"); err != nil {
+		return
+	}
+	if rawAfterID := values.Get("after_id"); rawAfterID != "" {
+		query.AfterID, err = strconv.ParseUint(rawAfterID, 10, 64)
+		if err != nil {
+			err = fmt.Errorf("invalid after_id: %s", rawAfterID)
+			return
+		}
+	}
+	if rawLimit := values.Get("limit"); rawLimit != "" {
+		query.Limit, err = strconv.Atoi(rawLimit)
+		if err != nil || query.Limit < 0 {
+			err = fmt.Errorf("invalid limit: %s", rawLimit)
+			return
+		}
+	}
+	return
+}
+
+func parseTime(values url.Values, key string) (time.Time, error) {
+	raw := values.Get(key)
+	if raw == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s: %s", key, raw)
+	}
+	return t, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &errorResponse{Error: message})
+}
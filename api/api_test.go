@@ -0,0 +1,110 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestHandler returns the handler of a store with the interactions 1 of
+// "red", 2 of "blue" and 3 without owner. The API key "admin" authenticates the
+// administrator and "red" the user red.
+func newTestHandler(t *testing.T) (http.Handler, ohren.Store) {
+	store := ohren.NewMemoryStore()
+	for _, owner := range []string{"red", "blue", ""} {
+		if err := store.Add(&ohren.Interaction{Type: string(ohren.RequestTypeDNS), Owner: owner}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	authenticator := auth.NewAuthenticator([]string{"admin"})
+	authenticator.AddUser("red", "", []string{"red"})
+	return authenticator.Require(NewHandler(store)), store
+}
+
+func TestHandlerOwners(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		method string
+		path   string
+		status int
+		// ids are the returned interactions
+		ids []uint64
+		// deleted is the amount of deleted interactions
+		deleted int
+		// kept are the interactions left in the store
+		kept []uint64
+	}{
+		{name: "admin lists all", key: "admin", method: http.MethodGet, path: "/interactions", status: http.StatusOK, ids: []uint64{1, 2, 3}},
+		{name: "admin filters by owner", key: "admin", method: http.MethodGet, path: "/interactions?owner=blue", status: http.StatusOK, ids: []uint64{2}},
+		{name: "admin fetches foreign", key: "admin", method: http.MethodGet, path: "/interactions/2", status: http.StatusOK, ids: []uint64{2}},
+		{name: "admin deletes foreign", key: "admin", method: http.MethodDelete, path: "/interactions/2", status: http.StatusOK, deleted: 1, kept: []uint64{1, 3}},
+		{name: "user lists own", key: "red", method: http.MethodGet, path: "/interactions", status: http.StatusOK, ids: []uint64{1}},
+		{name: "user filters by foreign owner", key: "red", method: http.MethodGet, path: "/interactions?owner=blue", status: http.StatusOK, ids: []uint64{1}},
+		{name: "user fetches own", key: "red", method: http.MethodGet, path: "/interactions/1", status: http.StatusOK, ids: []uint64{1}},
+		{name: "user fetches foreign", key: "red", method: http.MethodGet, path: "/interactions/2", status: http.StatusNotFound},
+		{name: "user fetches unowned", key: "red", method: http.MethodGet, path: "/interactions/3", status: http.StatusNotFound},
+		{name: "user deletes foreign", key: "red", method: http.MethodDelete, path: "/interactions/2", status: http.StatusNotFound, kept: []uint64{1, 2, 3}},
+		{name: "user deletes all", key: "red", method: http.MethodDelete, path: "/interactions", status: http.StatusOK, deleted: 1, kept: []uint64{2, 3}},
+		{name: "user deletes foreign owner", key: "red", method: http.MethodDelete, path: "/interactions?owner=blue", status: http.StatusOK, deleted: 1, kept: []uint64{2, 3}},
+		{name: "unauthenticated", method: http.MethodGet, path: "/interactions", status: http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler, store := newTestHandler(t)
+			request := httptest.NewRequest(test.method, test.path, nil)
+			if test.key != "" {
+				request.Header.Set("X-API-Key", test.key)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Fatalf("status %d, want %d: %s", recorder.Code, test.status, recorder.Body)
+			}
+			if test.status == http.StatusOK && test.method == http.MethodGet {
+				var interactions []*ohren.Interaction
+				// lists are arrays, single interactions objects
+				if recorder.Body.Bytes()[0] == '[' {
+					if err := json.Unmarshal(recorder.Body.Bytes(), &interactions); err != nil {
+						t.Fatal(err)
+					}
+				} else {
+					interaction := new(ohren.Interaction)
+					if err := json.Unmarshal(recorder.Body.Bytes(), interaction); err != nil {
+						t.Fatal(err)
+					}
+					interactions = append(interactions, interaction)
+				}
+				assertIDs(t, "returned", interactions, test.ids)
+			}
+			if test.status == http.StatusOK && test.method == http.MethodDelete {
+				var response deleteResponse
+				if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+					t.Fatal(err)
+				}
+				if response.Deleted != test.deleted {
+					t.Errorf("deleted %d, want %d", response.Deleted, test.deleted)
+				}
+			}
+			if test.kept != nil {
+				interactions, _ := store.Find(ohren.Query{})
+				assertIDs(t, "kept", interactions, test.kept)
+			}
+		})
+	}
+}
+
+func assertIDs(t *testing.T, name string, interactions []*ohren.Interaction, ids []uint64) {
+	t.Helper()
+	if len(interactions) != len(ids) {
+		t.Fatalf("%d interactions %s, want %v", len(interactions), name, ids)
+	}
+	for i, interaction := range interactions {
+		if interaction.ID != ids[i] {
+			t.Errorf("interaction %d %s has ID %d, want %d", i+1, name, interaction.ID, ids[i])
+		}
+	}
+}
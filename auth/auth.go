@@ -0,0 +1,224 @@
+// Package auth protects the admin server with API keys and HTTP basic authentication.
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// APIKeyQueryParameter can be used to pass the API key where headers can not be
+// set, e.g. for websockets opened by a browser.
+const APIKeyQueryParameter = "api_key"
+
+// AdminName is the name of the administrator if no basic auth username is configured.
+const AdminName = "admin"
+
+type contextKey int
+
+const userContextKey contextKey = 0
+
+// User is an authenticated principal.
+type User struct {
+	Name string
+	// Admin users can access the interactions of all users.
+	Admin    bool
+	password string
+}
+
+// Owner returns the owner of the interactions the user may access or an empty
+// string if the user may access all interactions.
+func (u *User) Owner() string {
+	if u.Admin {
+		return ""
+	}
+	return u.Name
+}
+
+// CanAccess returns true if the user may access interactions of the owner.
+func (u *User) CanAccess(owner string) bool {
+	return u.Admin || u.Name == owner
+}
+
+type apiKey struct {
+	hash [sha256.Size]byte
+	user *User
+}
+
+type Authenticator struct {
+	apiKeys []apiKey
+	users   map[string]*User
+	// AllowedOrigins contains the origins (e.g. "https://example.com") which
+	// may send requests. "*" allows all origins. Requests from the same origin
+	// and requests without an origin are always allowed.
+	AllowedOrigins []string
+}
+
+// NewAuthenticator creates an authenticator accepting the API keys as administrator.
+func NewAuthenticator(apiKeys []string) *Authenticator {
+	a := &Authenticator{
+		users: make(map[string]*User),
+	}
+	admin := &User{
+		Name:  AdminName,
+		Admin: true,
+	}
+	a.addKeys(admin, apiKeys)
+	return a
+}
+
+func (a *Authenticator) addKeys(user *User, apiKeys []string) {
+	for _, key := range apiKeys {
+		if key == "" {
+			continue
+		}
+		a.apiKeys = append(a.apiKeys, apiKey{
+			hash: sha256.Sum256([]byte(key)),
+			user: user,
+		})
+	}
+}
+
+// SetBasicAuth enables basic authentication for the administrator.
+func (a *Authenticator) SetBasicAuth(username string, password string) {
+	if password == "" {
+		return
+	}
+	if username == "" {
+		username = AdminName
+	}
+	a.users[username] = &User{
+		Name:     username,
+		Admin:    true,
+		password: password,
+	}
+}
+
+// AddUser adds a user which can only access its own interactions. The user can
+// log in with basic authentication if the password is not empty.
+func (a *Authenticator) AddUser(name string, password string, apiKeys []string) *User {
+	user := &User{
+		Name:     name,
+		password: password,
+	}
+	if password != "" {
+		a.users[name] = user
+	}
+	a.addKeys(user, apiKeys)
+	return user
+}
+
+// Enabled returns false if no credentials are configured and every request is accepted.
+func (a *Authenticator) Enabled() bool {
+	return len(a.apiKeys) > 0 || len(a.users) > 0
+}
+
+func (a *Authenticator) hasBasicAuth() bool {
+	return len(a.users) > 0
+}
+
+// Authenticate checks the credentials of the request and returns the user. The API
+// key is taken from the Authorization header (with or without "Bearer" prefix),
+// the X-API-Key header or the api_key query parameter. If authentication is not
+// enabled, every request is authenticated as administrator.
+func (a *Authenticator) Authenticate(r *http.Request) (*User, bool) {
+	if !a.Enabled() {
+		return &User{Name: AdminName, Admin: true}, true
+	}
+	if username, password, ok := r.BasicAuth(); ok && a.hasBasicAuth() {
+		user, found := a.users[username]
+		if !found {
+			return nil, false
+		}
+		return user, equal(password, user.password)
+	}
+	for _, key := range requestKeys(r) {
+		if user := a.checkKey(key); user != nil {
+			return user, true
+		}
+	}
+	return nil, false
+}
+
+func (a *Authenticator) checkKey(key string) (user *User) {
+	hash := sha256.Sum256([]byte(key))
+	for _, apiKey := range a.apiKeys {
+		if subtle.ConstantTimeCompare(hash[:], apiKey.hash[:]) == 1 {
+			user = apiKey.user
+		}
+	}
+	return
+}
+
+func requestKeys(r *http.Request) (keys []string) {
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		if len(authorization) > 7 && strings.EqualFold(authorization[:7], "bearer ") {
+			authorization = authorization[7:]
+		}
+		keys = append(keys, strings.TrimSpace(authorization))
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		keys = append(keys, key)
+	}
+	if key := r.URL.Query().Get(APIKeyQueryParameter); key != "" {
+		keys = append(keys, key)
+	}
+	return
+}
+
+func equal(a, b string) bool {
+	hashA := sha256.Sum256([]byte(a))
+	hashB := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(hashA[:], hashB[:]) == 1
+}
+
+// CheckOrigin returns true if the request has no Origin header, comes from the
+// same host or from one of the allowed origins.
+func (a *Authenticator) CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range a.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	originUrl, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(originUrl.Host, r.Host)
+}
+
+// Require only passes requests with valid credentials and an allowed origin to
+// the handler. The user can be retrieved from the request with UserFromRequest.
+func (a *Authenticator) Require(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !a.CheckOrigin(r) {
+			log.Printf("rejected request from origin %s", r.Header.Get("Origin"))
+			http.Error(w, "origin not allowed", http.StatusForbidden)
+			return
+		}
+		user, ok := a.Authenticate(r)
+		if !ok {
+			if a.hasBasicAuth() {
+				w.Header().Set("WWW-Authenticate", `Basic realm="ohren", charset="UTF-8"`)
+			}
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	})
+}
+
+// UserFromRequest returns the user authenticated by Require or nil if the
+// request did not pass Require.
+func UserFromRequest(r *http.Request) *User {
+	user, _ := r.Context().Value(userContextKey).(*User)
+	return user
+}
@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func newTestAuthenticator() *Authenticator {
+	a := NewAuthenticator([]string{"key", ""})
+	a.SetBasicAuth("root", "secret")
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator *Authenticator
+		url           string
+		header        map[string]string
+		username      string
+		password      string
+		user          string
+		ok            bool
+	}{
+		{name: "no credentials", authenticator: newTestAuthenticator()},
+		{name: "bearer", authenticator: newTestAuthenticator(), header: map[string]string{"Authorization": "Bearer key"}, user: AdminName, ok: true},
+		{name: "bearer ignores the case", authenticator: newTestAuthenticator(), header: map[string]string{"Authorization": "bearer key"}, user: AdminName, ok: true},
+		{name: "authorization without bearer", authenticator: newTestAuthenticator(), header: map[string]string{"Authorization": "key"}, user: AdminName, ok: true},
+		{name: "wrong bearer", authenticator: newTestAuthenticator(), header: map[string]string{"Authorization": "Bearer kex"}},
+		{name: "api key header", authenticator: newTestAuthenticator(), header: map[string]string{"X-API-Key": "key"}, user: AdminName, ok: true},
+		{name: "wrong api key header", authenticator: newTestAuthenticator(), header: map[string]string{"X-API-Key": "ke"}},
+		{name: "query parameter", authenticator: newTestAuthenticator(), url: "/?api_key=key", user: AdminName, ok: true},
+		{name: "wrong query parameter", authenticator: newTestAuthenticator(), url: "/?api_key=keys"},
+		{name: "basic auth", authenticator: newTestAuthenticator(), username: "root", password: "secret", user: "root", ok: true},
+		{name: "wrong password", authenticator: newTestAuthenticator(), username: "root", password: "secrets"},
+		{name: "unknown user", authenticator: newTestAuthenticator(), username: "admin", password: "secret"},
+		{name: "empty password", authenticator: newTestAuthenticator(), username: "root"},
+		// the API key is ignored if basic auth credentials are sent
+		{name: "wrong basic auth and api key", authenticator: newTestAuthenticator(), username: "root", password: "x", url: "/?api_key=key"},
+		{name: "basic auth without users", authenticator: NewAuthenticator([]string{"key"}), username: "root", password: "key"},
+		{name: "api key with basic auth header", authenticator: NewAuthenticator([]string{"key"}), username: "root", password: "x", url: "/?api_key=key", user: AdminName, ok: true},
+		{name: "not configured", authenticator: NewAuthenticator(nil), user: AdminName, ok: true},
+		// empty keys are ignored
+		{name: "only empty keys", authenticator: NewAuthenticator([]string{""}), user: AdminName, ok: true},
+		{name: "not configured with credentials", authenticator: NewAuthenticator(nil), username: "root", password: "x", user: AdminName, ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			url := test.url
+			if url == "" {
+				url = "/"
+			}
+			request := httptest.NewRequest(http.MethodGet, url, nil)
+			for name, value := range test.header {
+				request.Header.Set(name, value)
+			}
+			if test.username != "" {
+				request.SetBasicAuth(test.username, test.password)
+			}
+			user, ok := test.authenticator.Authenticate(request)
+			if ok != test.ok {
+				t.Fatalf("authenticated = %v, want %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			if user.Name != test.user || !user.Admin {
+				t.Errorf("authenticated as %+v, want the administrator %s", user, test.user)
+			}
+		})
+	}
+}
+
+func TestCheckOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		origin  string
+		ok      bool
+	}{
+		{name: "no origin", ok: true},
+		{name: "same host", origin: "http://ohren.example.com:8000", ok: true},
+		{name: "same host other scheme", origin: "https://ohren.example.com:8000", ok: true},
+		{name: "same host other port", origin: "http://ohren.example.com:8001"},
+		{name: "foreign", origin: "https://evil.example.com"},
+		{name: "null", origin: "null"},
+		{name: "allowed", allowed: []string{"https://app.example.com/"}, origin: "https://app.example.com", ok: true},
+		{name: "allowed ignores the case", allowed: []string{"https://APP.example.com"}, origin: "https://app.example.com", ok: true},
+		{name: "allowed other scheme", allowed: []string{"https://app.example.com"}, origin: "http://app.example.com"},
+		{name: "all allowed", allowed: []string{"*"}, origin: "https://evil.example.com", ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := newTestAuthenticator()
+			a.AllowedOrigins = test.allowed
+			request := httptest.NewRequest(http.MethodGet, "http://ohren.example.com:8000/", nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			if ok := a.CheckOrigin(request); ok != test.ok {
+				t.Errorf("CheckOrigin = %v, want %v", ok, test.ok)
+			}
+		})
+	}
+}
+
+func TestRequire(t *testing.T) {
+	tests := []struct {
+		name          string
+		authenticator *Authenticator
+		origin        string
+		key           string
+		status        int
+		challenge     bool
+	}{
+		{name: "authenticated", authenticator: newTestAuthenticator(), key: "key", status: http.StatusOK},
+		{name: "unauthenticated", authenticator: newTestAuthenticator(), status: http.StatusUnauthorized, challenge: true},
+		// only basic auth is challenged
+		{name: "unauthenticated without basic auth", authenticator: NewAuthenticator([]string{"key"}), status: http.StatusUnauthorized},
+		{name: "foreign origin", authenticator: newTestAuthenticator(), origin: "https://evil.example.com", key: "key", status: http.StatusForbidden},
+		{name: "foreign origin without authentication", authenticator: NewAuthenticator(nil), origin: "https://evil.example.com", status: http.StatusForbidden},
+		{name: "not configured", authenticator: NewAuthenticator(nil), status: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var user *User
+			handler := test.authenticator.Require(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				user = UserFromRequest(r)
+			}))
+			request := httptest.NewRequest(http.MethodGet, "http://ohren.example.com/", nil)
+			if test.origin != "" {
+				request.Header.Set("Origin", test.origin)
+			}
+			if test.key != "" {
+				request.Header.Set("X-API-Key", test.key)
+			}
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, request)
+			if recorder.Code != test.status {
+				t.Errorf("status %d, want %d", recorder.Code, test.status)
+			}
+			if challenge := recorder.Header().Get("WWW-Authenticate") != ""; challenge != test.challenge {
+				t.Errorf("WWW-Authenticate %q", recorder.Header().Get("WWW-Authenticate"))
+			}
+			if passed := user != nil; passed != (test.status == http.StatusOK) {
+				t.Errorf("handler called with user %+v", user)
+			}
+			if user != nil && !user.Admin {
+				t.Errorf("user %+v is no administrator", user)
+			}
+		})
+	}
+	if user := UserFromRequest(httptest.NewRequest(http.MethodGet, "/", nil)); user != nil {
+		t.Errorf("user %+v without Require", user)
+	}
+}
+
+func TestUsers(t *testing.T) {
+	a := newTestAuthenticator()
+	a.AddUser("red", "red password", []string{"red key"})
+	// users without password can only use API keys
+	a.AddUser("blue", "", []string{"blue key"})
+	tests := []struct {
+		name     string
+		username string
+		password string
+		key      string
+		user     string
+		ok       bool
+	}{
+		{name: "basic auth", username: "red", password: "red password", user: "red", ok: true},
+		{name: "wrong password", username: "red", password: "secret"},
+		{name: "api key", key: "red key", user: "red", ok: true},
+		{name: "api key without password", key: "blue key", user: "blue", ok: true},
+		{name: "basic auth without password", username: "blue", password: ""},
+		{name: "admin", key: "key", user: AdminName, ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.username != "" {
+				request.SetBasicAuth(test.username, test.password)
+			}
+			if test.key != "" {
+				request.Header.Set("X-API-Key", test.key)
+			}
+			user, ok := a.Authenticate(request)
+			if ok != test.ok {
+				t.Fatalf("authenticated = %v, want %v", ok, test.ok)
+			}
+			if !ok {
+				return
+			}
+			if user.Name != test.user {
+				t.Fatalf("authenticated as %s, want %s", user.Name, test.user)
+			}
+			admin := test.user == AdminName
+			if user.Admin != admin {
+				t.Errorf("admin = %v", user.Admin)
+			}
+			owner := test.user
+			if admin {
+				owner = ""
+			}
+			if user.Owner() != owner {
+				t.Errorf("owner %q, want %q", user.Owner(), owner)
+			}
+			for _, other := range []string{"red", "blue", ""} {
+				if access := user.CanAccess(other); access != (admin || other == test.user) {
+					t.Errorf("access to the interactions of %q = %v", other, access)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package ohren
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxBodySize is the default amount of bytes of a body which are captured.
+const DefaultMaxBodySize = 64 * 1024
+
+// CapturedBody is a body which was read up to a limit.
+type CapturedBody struct {
+	// Data contains the captured bytes
+	Data []byte `json:"data"`
+	// Size is the total size of the body
+	Size int64 `json:"size"`
+	// Truncated is true if not the whole body was captured
+	Truncated bool `json:"truncated"`
+}
+
+// BodyDetails is implemented by request details which captured a request body.
+type BodyDetails interface {
+	RequestBody() *CapturedBody
+}
+
+// CaptureBody reads the body and keeps at most limit bytes. The rest of the body
+// is discarded.
+func CaptureBody(body io.Reader, limit int64) (*CapturedBody, error) {
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, limit))
+	captured := &CapturedBody{
+		Data: data,
+		Size: int64(len(data)),
+	}
+	if err != nil {
+		return captured, err
+	}
+	rest, err := io.Copy(ioutil.Discard, body)
+	captured.Size += rest
+	captured.Truncated = rest > 0
+	return captured, err
+}
+
+// NewCapturedBody captures the data completely.
+func NewCapturedBody(data []byte) *CapturedBody {
+	return &CapturedBody{
+		Data: data,
+		Size: int64(len(data)),
+	}
+}
+
+// IsText returns true if the data is valid UTF-8 without control characters
+// other than whitespace.
+func (b *CapturedBody) IsText() bool {
+	data := b.Data
+	if b.Truncated {
+		// the last character may be cut off
+		for i := 1; i < utf8.UTFMax && i <= len(data); i++ {
+			if utf8.RuneStart(data[len(data)-i]) {
+				if !utf8.FullRune(data[len(data)-i:]) {
+					data = data[:len(data)-i]
+				}
+				break
+			}
+		}
+	}
+	if !utf8.Valid(data) {
+		return false
+	}
+	for _, r := range string(data) {
+		if unicode.IsControl(r) && r != '\n' && r != '\r' && r != '\t' {
+			return false
+		}
+	}
+	return true
+}
+
+// String returns the body as text or as hex dump for binary content followed by
+// a note if the body was truncated.
+func (b *CapturedBody) String() string {
+	if b == nil {
+		return ""
+	}
+	var description string
+	if b.IsText() {
+		description = string(b.Data)
+	} else {
+		description = hex.Dump(b.Data)
+	}
+	if b.Truncated {
+		description += fmt.Sprintf("\n[truncated: %d of %d bytes captured]", len(b.Data), b.Size)
+	}
+	return description
+}
@@ -0,0 +1,156 @@
+package ohren
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestCaptureBody(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		limit     int64
+		data      string
+		size      int64
+		truncated bool
+	}{
+		{name: "empty", body: "", limit: 10, data: "", size: 0},
+		{name: "below limit", body: "hello", limit: 10, data: "hello", size: 5},
+		{name: "at limit", body: "0123456789", limit: 10, data: "0123456789", size: 10},
+		{name: "above limit", body: "0123456789abc", limit: 10, data: "0123456789", size: 13, truncated: true},
+		{name: "default limit", body: strings.Repeat("x", DefaultMaxBodySize+1), limit: 0, data: strings.Repeat("x", DefaultMaxBodySize), size: DefaultMaxBodySize + 1, truncated: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := strings.NewReader(test.body)
+			captured, err := CaptureBody(body, test.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(captured.Data) != test.data || captured.Size != test.size || captured.Truncated != test.truncated {
+				t.Errorf("captured %d bytes, size %d, truncated %v; want %d bytes, size %d, truncated %v",
+					len(captured.Data), captured.Size, captured.Truncated, len(test.data), test.size, test.truncated)
+			}
+			if body.Len() != 0 {
+				t.Errorf("%d bytes of the body were not read", body.Len())
+			}
+		})
+	}
+}
+
+func TestCapturedBodyIsText(t *testing.T) {
+	tests := []struct {
+		name      string
+		data      []byte
+		truncated bool
+		text      bool
+	}{
+		{name: "empty", data: nil, text: true},
+		{name: "ascii", data: []byte("a=1&b=2"), text: true},
+		{name: "whitespace", data: []byte("line\r\n\tindented\n"), text: true},
+		{name: "utf-8", data: []byte("grüezi ✓"), text: true},
+		{name: "control character", data: []byte("a\x00b"), text: false},
+		{name: "escape", data: []byte("\x1b[31mred"), text: false},
+		{name: "invalid utf-8", data: []byte{'a', 0xff, 'b'}, text: false},
+		{name: "cut off character", data: []byte("ok ✓")[:5], truncated: true, text: true},
+		{name: "cut off character not truncated", data: []byte("ok ✓")[:5], text: false},
+		{name: "binary truncated", data: []byte{0x89, 'P', 'N', 'G', 0x0d, 0x0a, 0x1a, 0x0a}, truncated: true, text: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			body := &CapturedBody{Data: test.data, Size: int64(len(test.data)), Truncated: test.truncated}
+			if text := body.IsText(); text != test.text {
+				t.Errorf("IsText() = %v, want %v", text, test.text)
+			}
+		})
+	}
+}
+
+func TestCapturedBodyString(t *testing.T) {
+	tests := []struct {
+		name string
+		body *CapturedBody
+		want string
+	}{
+		{name: "nil", body: nil, want: ""},
+		{name: "text", body: NewCapturedBody([]byte("<x>&xxe;</x>")), want: "<x>&xxe;</x>"},
+		{
+			name: "binary",
+			body: NewCapturedBody([]byte{0x00, 0x01, 'A'}),
+			want: "00000000  00 01 41                                          |..A|\n",
+		},
+		{
+			name: "truncated text",
+			body: &CapturedBody{Data: []byte("abc"), Size: 10, Truncated: true},
+			want: "abc\n[truncated: 3 of 10 bytes captured]",
+		},
+		{
+			name: "truncated binary",
+			body: &CapturedBody{Data: []byte{0xff}, Size: 2, Truncated: true},
+			want: "00000000  ff                                                |.|\n\n[truncated: 1 of 2 bytes captured]",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.body.String(); got != test.want {
+				t.Errorf("String() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (f *failingReader) Read(p []byte) (int, error) {
+	if len(f.data) == 0 {
+		return 0, f.err
+	}
+	n := copy(p, f.data)
+	f.data = f.data[n:]
+	return n, nil
+}
+
+func TestBodyRecorder(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      io.Reader
+		limit     int64
+		read      int
+		data      string
+		size      int64
+		truncated bool
+		readError bool
+	}{
+		{name: "read completely", body: strings.NewReader("hello"), limit: 10, read: -1, data: "hello", size: 5},
+		{name: "read above limit", body: strings.NewReader("0123456789abc"), limit: 4, read: -1, data: "0123", size: 13, truncated: true},
+		{name: "drained after partial read", body: strings.NewReader("0123456789"), limit: 20, read: 3, data: "0123456789", size: 10},
+		{name: "unread body is drained", body: strings.NewReader("abc"), limit: 20, read: 0, data: "abc", size: 3},
+		{name: "read error", body: &failingReader{data: []byte("ab"), err: errors.New("reset")}, limit: 20, read: -1, data: "ab", size: 2, readError: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := newBodyRecorder(ioutil.NopCloser(test.body), test.limit)
+			if test.read < 0 {
+				_, _ = io.Copy(ioutil.Discard, recorder)
+			} else if test.read > 0 {
+				_, _ = io.ReadFull(recorder, make([]byte, test.read))
+			}
+			recorder.drain()
+			captured := recorder.captured()
+			if !bytes.Equal(captured.Data, []byte(test.data)) || captured.Size != test.size || captured.Truncated != test.truncated {
+				t.Errorf("captured %q, size %d, truncated %v; want %q, size %d, truncated %v",
+					captured.Data, captured.Size, captured.Truncated, test.data, test.size, test.truncated)
+			}
+			if (recorder.readError != nil) != test.readError {
+				t.Errorf("read error = %v", recorder.readError)
+			}
+		})
+	}
+}
@@ -15,6 +15,7 @@ type ResettableReader interface {
 
 type recordingReader struct {
 	buffer         bytes.Buffer
+	reader         io.Reader
 	recordedReader io.Reader
 	usedReader     io.Reader
 }
@@ -37,8 +38,10 @@ func (r *recordingReader) ReadByte() (byte, error) {
 	return ReadByte(r)
 }
 
+// Reset replays the recorded bytes. Bytes read after the reset are not recorded,
+// so long lived connections are not buffered completely.
 func (r *recordingReader) Reset() error {
-	r.usedReader = io.MultiReader(&r.buffer, r.recordedReader)
+	r.usedReader = io.MultiReader(&r.buffer, r.reader)
 	return nil
 }
 
@@ -51,6 +54,7 @@ func newResettableRecordedReader(r io.Reader) (reader *recordingReader) {
 		panic("reader is nil")
 	}
 	reader = new(recordingReader)
+	reader.reader = r
 	reader.recordedReader = io.TeeReader(r, &reader.buffer)
 	reader.usedReader = reader.recordedReader
 	return reader
@@ -8,15 +8,52 @@ import (
 	"io"
 	"log"
 	"net"
+	"os"
+	"strings"
+	"time"
 )
 
+// DnsForeignPolicy selects the response to queries for names outside of the zones.
+type DnsForeignPolicy string
+
+const (
+	DnsForeignRefuse   DnsForeignPolicy = "refuse"
+	DnsForeignNXDomain DnsForeignPolicy = "nxdomain"
+	DnsForeignDrop     DnsForeignPolicy = "drop"
+)
+
+// ErrNotRecorded is returned by responders for requests which should not be recorded.
+var ErrNotRecorded = errors.New("request not recorded")
+
+// DnsResponder is authoritative for the Zone and the zones of the SOA records
+// in Records. If there are no zones, it answers all names.
 type DnsResponder struct {
 	Addresses []net.IP
 	TTL       uint32
+	// Zone is the domain of the default SOA and NS records, e.g. "example.com."
+	Zone string
+	// Records are answered before the Addresses. Records of a name starting
+	// with "*." answer queries for all subdomains without own records.
+	Records []dns.RR
+	// Foreign is the response to names outside of the zones, defaults to DnsForeignRefuse
+	Foreign DnsForeignPolicy
+	// RecordForeign records queries for names outside of the zones, otherwise
+	// Respond returns ErrNotRecorded for them
+	RecordForeign bool
+	// Rebinding answers rebinding names of the Zone before the Records if it is set
+	Rebinding *DnsRebinding
 }
 
 const defaultTTL = 60 * 5
 
+// negativeTTL is the minimum TTL of the default SOA. Resolvers cache missing
+// names for this time, it is short because new tokens are used all the time.
+const negativeTTL = 60
+
+// dnsTcpTimeout limits the time to read a query from and write the answer to a
+// tcp connection.
+const dnsTcpTimeout = 5 * time.Second
+
 func (d DnsResponder) Respond(conn net.Conn) (RequestDetails, error) {
 	var err error
 	udpConn, ok := conn.(*net.UDPConn)
@@ -31,12 +68,16 @@ func (d DnsResponder) Respond(conn net.Conn) (RequestDetails, error) {
 		}
 		m = buffer[:n]
 	} else {
+		// idle tcp connections must not occupy a worker of the listener
+		if err := conn.SetDeadline(time.Now().Add(dnsTcpTimeout)); err != nil {
+			return nil, err
+		}
 		var length uint16
 		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
 			return nil, err
 		}
 
-		m := make([]byte, length)
+		m = make([]byte, length)
 		if _, err := io.ReadFull(conn, m); err != nil {
 			return nil, err
 		}
@@ -57,47 +98,71 @@ func (d DnsResponder) Respond(conn net.Conn) (RequestDetails, error) {
 		return nil, err
 	}
 
-	answersByHost := make(map[string]uint32)
-	const (
-		AAnswered    uint32 = 1 << iota
-		AAAAAnswered uint32 = 1 << iota
-	)
-
+	answered := make(map[dns.Question]bool)
+	var hosts, types []string
+	foreign := false
+	resp.Authoritative = d.hasZones()
 	for _, question := range req.Question {
 		log.Println(question)
-		if question.Qclass == dns.ClassINET {
-			switch question.Qtype {
-			case dns.TypeANY:
-				if 0 == answersByHost[question.Name] & AAnswered {
-					log.Println("adding A answers")
-					resp.Answer = append(resp.Answer, d.getARecords(question.Name)...)
-					answersByHost[question.Name] |= AAnswered
-				}
-				if 0 == answersByHost[question.Name] & AAAAAnswered {
-					resp.Answer = append(resp.Answer, d.getAAAARecord(question.Name)...)
-					answersByHost[question.Name] |= AAAAAnswered
-				}
-			case dns.TypeA:
-				if 0 == answersByHost[question.Name] & AAnswered {
-					log.Println("adding A answers")
-					resp.Answer = append(resp.Answer, d.getARecords(question.Name)...)
-					answersByHost[question.Name] |= AAnswered
-				}
-			case dns.TypeAAAA:
-				if 0 == answersByHost[question.Name] & AAAAAnswered {
-					resp.Answer = append(resp.Answer, d.getAAAARecord(question.Name)...)
-					answersByHost[question.Name] |= AAAAAnswered
-				}
-			}
+		if question.Qclass != dns.ClassINET {
+			continue
+		}
+		key := dns.Question{Name: strings.ToLower(question.Name), Qtype: question.Qtype, Qclass: question.Qclass}
+		if answered[key] {
+			continue
+		}
+		answered[key] = true
+		hosts = appendUnique(hosts, key.Name)
+		types = appendUnique(types, dns.TypeToString[question.Qtype])
+		zone, ok := d.zone(question.Name)
+		if !ok {
+			foreign = true
+			continue
+		}
+		answers := d.answer(question.Name, question.Qtype)
+		resp.Answer = append(resp.Answer, answers...)
+		if len(answers) == 0 && zone != "" {
+			// NODATA, every name in the zone exists
+			resp.Ns = append(resp.Ns, d.negativeSOARecord(zone))
 		}
 	}
 
+	if foreign {
+		d.foreignReply(resp)
+	}
+	if foreign && d.Foreign == DnsForeignDrop {
+		log.Printf("dropped query for foreign names: %v", hosts)
+	} else if err := d.write(conn, udpSession, req, resp); err != nil {
+		return nil, err
+	}
+	if foreign && !d.RecordForeign {
+		return nil, ErrNotRecorded
+	}
+	return DnsRequestDetails{
+		RequestedHosts: hosts,
+		RequestedTypes: types,
+		Request:        req,
+		Response:       resp,
+	}, nil
+}
+
+// write sends the response over the connection or the udp session.
+func (d DnsResponder) write(conn net.Conn, udpSession *dns.SessionUDP, req *dns.Msg, resp *dns.Msg) error {
+	udpConn, _ := conn.(*net.UDPConn)
+	if udpSession != nil {
+		size := dns.MinMsgSize
+		if opt := req.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+			size = int(opt.UDPSize())
+		}
+		// the client retries over tcp, which is served by a TcpListener
+		resp.Truncate(size)
+	}
 	respBytes, err := resp.Pack()
 	if err != nil {
-		return nil, fmt.Errorf("error packing dns: %s", err)
+		return fmt.Errorf("error packing dns: %s", err)
 	}
 	if len(respBytes) > dns.MaxMsgSize {
-		return nil, errors.New("response too big")
+		return errors.New("response too big")
 	}
 	//respBytes = append([]byte{0x00}, respBytes...)
 
@@ -107,30 +172,219 @@ func (d DnsResponder) Respond(conn net.Conn) (RequestDetails, error) {
 		binary.BigEndian.PutUint16(lengthBytes, uint16(resp.Len()))
 		respBytes = append(lengthBytes, respBytes...)
 		_, err = conn.Write(respBytes)
-		if err != nil {
-			return nil, err
-		}
+		return err
+	}
+	_, err = dns.WriteToSessionUDP(udpConn, respBytes, udpSession)
+	return err
+}
+
+// foreignReply replaces the answers of the response according to the Foreign policy.
+func (d DnsResponder) foreignReply(resp *dns.Msg) {
+	resp.Answer = nil
+	resp.Ns = nil
+	resp.Authoritative = false
+	if d.Foreign == DnsForeignNXDomain {
+		resp.Rcode = dns.RcodeNameError
 	} else {
-		_, err = dns.WriteToSessionUDP(udpConn, respBytes, udpSession)
-		if err != nil {
-			return nil, err
+		resp.Rcode = dns.RcodeRefused
+	}
+}
+
+// hasZones returns true if the responder is authoritative for any zone.
+func (d DnsResponder) hasZones() bool {
+	if d.Zone != "" {
+		return true
+	}
+	for _, record := range d.Records {
+		if record.Header().Rrtype == dns.TypeSOA {
+			return true
 		}
 	}
+	return false
+}
 
-	hosts := make([]string, 0, len(answersByHost))
-	for host := range answersByHost {
-		hosts = append(hosts, host)
+// zone returns the closest zone of the name. The zone is empty and ok is true if
+// the name is outside of the zones but has records or if there are no zones.
+func (d DnsResponder) zone(name string) (zone string, ok bool) {
+	name = dns.Fqdn(name)
+	if d.Zone != "" && dns.IsSubDomain(dns.Fqdn(d.Zone), name) {
+		zone = dns.Fqdn(d.Zone)
+	}
+	for _, record := range d.Records {
+		header := record.Header()
+		if header.Rrtype == dns.TypeSOA && dns.IsSubDomain(header.Name, name) && len(header.Name) > len(zone) {
+			zone = header.Name
+		}
+	}
+	if zone != "" {
+		return zone, true
 	}
+	return "", !d.hasZones() || len(d.records(name, dns.TypeANY)) > 0
+}
 
-	return DnsRequestDetails{
-		RequestedHosts: hosts,
-		Request:        req,
-		Response:       resp,
-	}, nil
+// negativeSOARecord returns the SOA of the zone for the authority section of
+// negative answers. The TTL is the minimum of the SOA TTL and its Minttl.
+func (d DnsResponder) negativeSOARecord(zone string) dns.RR {
+	var soa *dns.SOA
+	for _, record := range d.records(zone, dns.TypeSOA) {
+		if record, ok := record.(*dns.SOA); ok {
+			soa = dns.Copy(record).(*dns.SOA)
+			break
+		}
+	}
+	if soa == nil {
+		soa = d.getSOARecord().(*dns.SOA)
+	}
+	if soa.Minttl < soa.Hdr.Ttl {
+		soa.Hdr.Ttl = soa.Minttl
+	}
+	return soa
+}
+
+// answer returns the answers to a question for the name and the type.
+func (d DnsResponder) answer(name string, qtype uint16) []dns.RR {
+	if d.isRebinding(name) {
+		// configured wildcards must not hide rebinding names
+		switch qtype {
+		case dns.TypeA, dns.TypeANY:
+			return d.getARecords(name)
+		}
+		// rebinding only uses IPv4, clients must not prefer another address
+		return nil
+	}
+	records := d.records(name, qtype)
+	switch qtype {
+	case dns.TypeANY:
+		if len(d.records(name, dns.TypeA)) == 0 {
+			records = append(records, d.getARecords(name)...)
+		}
+		if len(d.records(name, dns.TypeAAAA)) == 0 {
+			records = append(records, d.getAAAARecord(name)...)
+		}
+		return records
+	case dns.TypeCNAME:
+		return records
+	}
+	if len(records) > 0 {
+		return records
+	}
+	if cname := d.records(name, dns.TypeCNAME); len(cname) > 0 {
+		return cname
+	}
+	switch qtype {
+	case dns.TypeA:
+		return d.getARecords(name)
+	case dns.TypeAAAA:
+		return d.getAAAARecord(name)
+	case dns.TypeSOA:
+		if d.isZone(name) {
+			return []dns.RR{d.getSOARecord()}
+		}
+	case dns.TypeNS:
+		if d.isZone(name) {
+			return d.getNSRecords()
+		}
+	}
+	return nil
+}
+
+// records returns the configured records of the name and the type or of the
+// closest wildcard if the name has no records. ANY matches all types.
+func (d DnsResponder) records(name string, qtype uint16) []dns.RR {
+	queried := dns.Fqdn(name)
+	name = strings.ToLower(queried)
+	var exact, wildcard []dns.RR
+	var wildcardName string
+	hasName := false
+	for _, record := range d.Records {
+		header := record.Header()
+		owner := strings.ToLower(header.Name)
+		if owner == name {
+			hasName = true
+			if qtype == dns.TypeANY || header.Rrtype == qtype {
+				exact = append(exact, record)
+			}
+			continue
+		}
+		if !strings.HasPrefix(owner, "*.") || !dns.IsSubDomain(owner[2:], name) {
+			continue
+		}
+		if qtype != dns.TypeANY && header.Rrtype != qtype {
+			continue
+		}
+		// the longest wildcard is the closest
+		if len(owner) > len(wildcardName) {
+			wildcardName = owner
+			wildcard = wildcard[:0]
+		}
+		if owner == wildcardName {
+			record = dns.Copy(record)
+			record.Header().Name = queried
+			wildcard = append(wildcard, record)
+		}
+	}
+	if hasName {
+		return exact
+	}
+	return wildcard
+}
+
+// isRebinding returns true if the name is answered by the Rebinding.
+func (d DnsResponder) isRebinding(name string) bool {
+	if d.Rebinding == nil {
+		return false
+	}
+	_, _, ok := d.Rebinding.parse(name, d.Zone)
+	return ok
+}
+
+func (d DnsResponder) isZone(name string) bool {
+	return d.Zone != "" && strings.EqualFold(dns.Fqdn(name), dns.Fqdn(d.Zone))
+}
+
+func (d DnsResponder) getSOARecord() dns.RR {
+	zone := dns.Fqdn(d.Zone)
+	return &dns.SOA{
+		Hdr: dns.RR_Header{
+			Name:   zone,
+			Rrtype: dns.TypeSOA,
+			Class:  dns.ClassINET,
+			Ttl:    d.ttl(),
+		},
+		Ns:      "ns1." + zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  negativeTTL,
+	}
+}
+
+func (d DnsResponder) getNSRecords() (records []dns.RR) {
+	zone := dns.Fqdn(d.Zone)
+	for _, ns := range []string{"ns1.", "ns2."} {
+		records = append(records, &dns.NS{
+			Hdr: dns.RR_Header{
+				Name:   zone,
+				Rrtype: dns.TypeNS,
+				Class:  dns.ClassINET,
+				Ttl:    d.ttl(),
+			},
+			Ns: ns + zone,
+		})
+	}
+	return
 }
 
 func (d DnsResponder) getARecords(name string) (records []dns.RR) {
-	for _, ip := range d.Addresses {
+	addresses := d.Addresses
+	if d.Rebinding != nil {
+		if ip := d.Rebinding.Address(name, d.Zone, d.publicIPv4()); ip != nil {
+			addresses = []net.IP{ip}
+		}
+	}
+	for _, ip := range addresses {
 		ip = ip.To4()
 		if ip == nil {
 			continue
@@ -166,6 +420,16 @@ func (d DnsResponder) getAAAARecord(name string) (records []dns.RR) {
 	return
 }
 
+// publicIPv4 returns the first IPv4 address of the Addresses or nil.
+func (d DnsResponder) publicIPv4() net.IP {
+	for _, ip := range d.Addresses {
+		if ip = ip.To4(); ip != nil {
+			return ip
+		}
+	}
+	return nil
+}
+
 func (d DnsResponder) ttl() uint32 {
 	if d.TTL == 0 {
 		return defaultTTL
@@ -173,3 +437,32 @@ func (d DnsResponder) ttl() uint32 {
 		return d.TTL
 	}
 }
+
+// ReadZoneFile parses the records of an RFC 1035 zone file. Relative names are
+// below the origin unless the file sets $ORIGIN.
+func ReadZoneFile(path string, origin string) ([]dns.RR, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	parser := dns.NewZoneParser(file, dns.Fqdn(origin), path)
+	parser.SetIncludeAllowed(true)
+	var records []dns.RR
+	for record, ok := parser.Next(); ok; record, ok = parser.Next() {
+		records = append(records, record)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, v := range values {
+		if v == value {
+			return values
+		}
+	}
+	return append(values, value)
+}
@@ -0,0 +1,151 @@
+package ohren
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultRebindLabel is the label between the rebinding names and the zone
+	DefaultRebindLabel = "rebind"
+	// rebindStateTTL is the time the state of a name is kept after its last query
+	rebindStateTTL = time.Hour
+	// maxRebindStates limits the amount of names with a state
+	maxRebindStates = 10000
+)
+
+// DnsRebinding answers A queries for names like "a.<ip1>.b.<ip2>.rebind.<zone>"
+// with one of both IPv4 addresses and TTL 0. The addresses are dotted
+// ("a.1.2.3.4") or dashed ("a.1-2-3-4"). The first address defaults to the
+// public address of the responder and the second to the Target. Any labels
+// before the pattern, e.g. a correlation token, have their own state.
+type DnsRebinding struct {
+	// Label precedes the zone, defaults to DefaultRebindLabel
+	Label string
+	// Target is the second address if the name contains none, e.g. 127.0.0.1
+	Target net.IP
+	// Sequential answers First times with the first address and afterwards
+	// with the second address instead of alternating between them
+	Sequential bool
+	// First defaults to 1
+	First int
+
+	mutex  sync.Mutex
+	states map[string]*rebindState
+}
+
+type rebindState struct {
+	count    int
+	lastSeen time.Time
+}
+
+// Address returns the address for the next query of the name or nil if the name
+// is no rebinding name of the zone. The public address is the default first address.
+func (r *DnsRebinding) Address(name string, zone string, public net.IP) net.IP {
+	first, second, ok := r.parse(name, zone)
+	if !ok {
+		return nil
+	}
+	if first == nil {
+		first = public
+	}
+	if second == nil {
+		second = r.Target.To4()
+	}
+	if first == nil || second == nil {
+		return nil
+	}
+	if r.next(strings.ToLower(name)) {
+		return first
+	}
+	return second
+}
+
+// parse returns the addresses of the name, which are nil if they are not part of the name.
+func (r *DnsRebinding) parse(name string, zone string) (first net.IP, second net.IP, ok bool) {
+	label := r.Label
+	if label == "" {
+		label = DefaultRebindLabel
+	}
+	suffix := "." + strings.ToLower(label+"."+strings.TrimSuffix(zone, ".")+".")
+	name = strings.ToLower(name)
+	if zone == "" || !strings.HasSuffix(name, suffix) {
+		return nil, nil, false
+	}
+	labels := strings.Split(strings.TrimSuffix(name, suffix), ".")
+	for i := 0; i < len(labels); i++ {
+		if labels[i] != "a" && labels[i] != "b" {
+			continue
+		}
+		ip, n := parseRebindAddress(labels[i+1:])
+		if ip == nil {
+			continue
+		}
+		if labels[i] == "a" {
+			first = ip
+		} else {
+			second = ip
+		}
+		i += n
+	}
+	return first, second, true
+}
+
+// parseRebindAddress parses a dashed address from the first label or a dotted
+// address from the first four labels and returns the amount of used labels.
+func parseRebindAddress(labels []string) (net.IP, int) {
+	if len(labels) > 0 {
+		if ip := net.ParseIP(strings.ReplaceAll(labels[0], "-", ".")).To4(); ip != nil {
+			return ip, 1
+		}
+	}
+	if len(labels) >= 4 {
+		if ip := net.ParseIP(strings.Join(labels[:4], ".")).To4(); ip != nil {
+			return ip, 4
+		}
+	}
+	return nil, 0
+}
+
+// next advances the state of the name and returns true if the first address is answered.
+func (r *DnsRebinding) next(name string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	now := time.Now()
+	if r.states == nil {
+		r.states = make(map[string]*rebindState)
+	}
+	state, ok := r.states[name]
+	if !ok || now.Sub(state.lastSeen) > rebindStateTTL {
+		if len(r.states) >= maxRebindStates {
+			r.removeExpired(now)
+		}
+		state = new(rebindState)
+		r.states[name] = state
+	}
+	count := state.count
+	state.count++
+	state.lastSeen = now
+	if r.Sequential {
+		first := r.First
+		if first <= 0 {
+			first = 1
+		}
+		return count < first
+	}
+	return count%2 == 0
+}
+
+func (r *DnsRebinding) removeExpired(now time.Time) {
+	for name, state := range r.states {
+		if now.Sub(state.lastSeen) > rebindStateTTL {
+			delete(r.states, name)
+		}
+	}
+	if len(r.states) >= maxRebindStates {
+		// all names are in use, the oldest states are lost
+		r.states = make(map[string]*rebindState)
+	}
+}
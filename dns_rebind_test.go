@@ -0,0 +1,157 @@
+package ohren
+
+import (
+	"github.com/miekg/dns"
+	"net"
+	"testing"
+)
+
+func TestDnsRebindingParse(t *testing.T) {
+	tests := []struct {
+		name   string
+		label  string
+		first  string
+		second string
+		ok     bool
+	}{
+		{name: "a.1.2.3.4.b.127.0.0.1.rebind.example.com.", first: "1.2.3.4", second: "127.0.0.1", ok: true},
+		{name: "a.1-2-3-4.b.127-0-0-1.rebind.example.com.", first: "1.2.3.4", second: "127.0.0.1", ok: true},
+		{name: "a.1-2-3-4.b.10.0.0.1.rebind.example.com.", first: "1.2.3.4", second: "10.0.0.1", ok: true},
+		{name: "TOKEN.A.1.2.3.4.B.127.0.0.1.Rebind.Example.COM.", first: "1.2.3.4", second: "127.0.0.1", ok: true},
+		{name: "x.y.a.1.2.3.4.b.127.0.0.1.rebind.example.com.", first: "1.2.3.4", second: "127.0.0.1", ok: true},
+		{name: "b.127.0.0.1.rebind.example.com.", second: "127.0.0.1", ok: true},
+		{name: "token.a.1.2.3.4.rebind.example.com.", first: "1.2.3.4", ok: true},
+		{name: "token.rebind.example.com.", ok: true},
+		{name: "a.b.rebind.example.com.", ok: true},
+		{name: "a.1.2.3.rebind.example.com.", ok: true},
+		{name: "a.1.2.3.999.rebind.example.com.", ok: true},
+		{name: "a.::1.rebind.example.com.", ok: true},
+		{name: "a.1.2.3.4.rebind.example.org.", ok: false},
+		{name: "rebind.example.com.", ok: false},
+		{name: "a.1.2.3.4.other.example.com.", ok: false},
+		{name: "a.1.2.3.4.dns.example.com.", label: "dns", first: "1.2.3.4", ok: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rebinding := &DnsRebinding{Label: test.label}
+			first, second, ok := rebinding.parse(test.name, "example.com")
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if !equalIP(first, test.first) {
+				t.Errorf("first = %v, want %q", first, test.first)
+			}
+			if !equalIP(second, test.second) {
+				t.Errorf("second = %v, want %q", second, test.second)
+			}
+		})
+	}
+}
+
+func TestDnsRebindingAddress(t *testing.T) {
+	public := net.ParseIP("192.0.2.1")
+	tests := []struct {
+		name      string
+		rebinding *DnsRebinding
+		query     string
+		want      []string
+	}{
+		{
+			name:      "alternating",
+			rebinding: &DnsRebinding{},
+			query:     "a.1.2.3.4.b.127.0.0.1.rebind.example.com.",
+			want:      []string{"1.2.3.4", "127.0.0.1", "1.2.3.4", "127.0.0.1"},
+		},
+		{
+			name:      "sequential",
+			rebinding: &DnsRebinding{Sequential: true, First: 2},
+			query:     "a.1.2.3.4.b.127.0.0.1.rebind.example.com.",
+			want:      []string{"1.2.3.4", "1.2.3.4", "127.0.0.1", "127.0.0.1"},
+		},
+		{
+			name:      "sequential defaults to one",
+			rebinding: &DnsRebinding{Sequential: true},
+			query:     "a.1.2.3.4.b.127.0.0.1.rebind.example.com.",
+			want:      []string{"1.2.3.4", "127.0.0.1", "127.0.0.1"},
+		},
+		{
+			name:      "public and target",
+			rebinding: &DnsRebinding{Target: net.ParseIP("10.0.0.1")},
+			query:     "token.rebind.example.com.",
+			want:      []string{"192.0.2.1", "10.0.0.1", "192.0.2.1"},
+		},
+		{
+			name:      "no second address",
+			rebinding: &DnsRebinding{},
+			query:     "token.a.1.2.3.4.rebind.example.com.",
+			want:      []string{"", ""},
+		},
+		{
+			name:      "no rebinding name",
+			rebinding: &DnsRebinding{Target: net.ParseIP("10.0.0.1")},
+			query:     "token.example.com.",
+			want:      []string{""},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, want := range test.want {
+				got := test.rebinding.Address(test.query, "example.com", public)
+				if !equalIP(got, want) {
+					t.Fatalf("answer %d = %v, want %q", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDnsRebindingStatePerName(t *testing.T) {
+	rebinding := &DnsRebinding{}
+	first := "one.a.1.2.3.4.b.127.0.0.1.rebind.example.com."
+	second := "two.a.1.2.3.4.b.127.0.0.1.rebind.example.com."
+	if got := rebinding.Address(first, "example.com", nil); !equalIP(got, "1.2.3.4") {
+		t.Fatalf("first name = %v", got)
+	}
+	if got := rebinding.Address(second, "example.com", nil); !equalIP(got, "1.2.3.4") {
+		t.Fatalf("second name = %v, want its own state", got)
+	}
+	if got := rebinding.Address("ONE.a.1.2.3.4.b.127.0.0.1.rebind.example.com.", "example.com", nil); !equalIP(got, "127.0.0.1") {
+		t.Fatalf("first name again = %v, want state ignoring the case", got)
+	}
+}
+
+func TestDnsRebindingBeforeWildcard(t *testing.T) {
+	wildcard, err := dns.NewRR("*.example.com. 60 IN A 192.0.2.9")
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder := DnsResponder{
+		Zone:      "example.com",
+		Addresses: []net.IP{net.ParseIP("192.0.2.1")},
+		Records:   []dns.RR{wildcard},
+		Rebinding: &DnsRebinding{},
+	}
+	name := "token.a.1.2.3.4.b.127.0.0.1.rebind.example.com."
+	for _, want := range []string{"1.2.3.4", "127.0.0.1"} {
+		answers := responder.answer(name, dns.TypeA)
+		if len(answers) != 1 {
+			t.Fatalf("answers = %v", answers)
+		}
+		if a, ok := answers[0].(*dns.A); !ok || !equalIP(a.A, want) || a.Hdr.Ttl != 0 {
+			t.Fatalf("answer = %v, want %s with ttl 0", answers[0], want)
+		}
+	}
+	if answers := responder.answer(name, dns.TypeAAAA); len(answers) != 0 {
+		t.Errorf("AAAA answers = %v, want none", answers)
+	}
+	if answers := responder.answer("other.example.com.", dns.TypeA); len(answers) != 1 || !equalIP(answers[0].(*dns.A).A, "192.0.2.9") {
+		t.Errorf("wildcard answers = %v", answers)
+	}
+}
+
+func equalIP(ip net.IP, want string) bool {
+	if want == "" {
+		return ip == nil
+	}
+	return ip.Equal(net.ParseIP(want))
+}
@@ -0,0 +1,262 @@
+package ohren
+
+import (
+	"github.com/miekg/dns"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// exchangeDns sends the query over a stream connection to the responder and
+// returns the answer or nil if the responder sent none.
+func exchangeDns(t *testing.T, responder *DnsResponder, name string, qtype uint16) (*dns.Msg, RequestDetails, error) {
+	t.Helper()
+	server, client := net.Pipe()
+	defer client.Close()
+	type result struct {
+		details RequestDetails
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		details, err := responder.Respond(server)
+		server.Close()
+		done <- result{details, err}
+	}()
+	query := new(dns.Msg)
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	conn := &dns.Conn{Conn: client}
+	if err := conn.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteMsg(query); err != nil {
+		t.Fatal(err)
+	}
+	answer, readErr := conn.ReadMsg()
+	r := <-done
+	if readErr != nil {
+		answer = nil
+	}
+	return answer, r.details, r.err
+}
+
+func mustRR(t *testing.T, records ...string) (rrs []dns.RR) {
+	t.Helper()
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return
+}
+
+func TestDnsZones(t *testing.T) {
+	records := mustRR(t,
+		"www.example.com. 300 IN A 192.0.2.10",
+		"*.wild.example.com. 300 IN TXT \"wildcard\"",
+		"exact.wild.example.com. 300 IN TXT \"exact\"",
+		"alias.example.com. 300 IN CNAME www.example.com.",
+		"other.test. 3600 IN SOA ns.other.test. admin.other.test. 7 3600 600 86400 30",
+		"host.other.test. 300 IN A 192.0.2.20",
+	)
+	tests := []struct {
+		name          string
+		responder     DnsResponder
+		qname         string
+		qtype         uint16
+		rcode         int
+		authoritative bool
+		answers       []string
+		// negativeSOA is the name of the SOA expected in the authority section
+		negativeSOA string
+		negativeTTL uint32
+		dropped     bool
+		recorded    bool
+	}{
+		{name: "public address", qname: "token.example.com", qtype: dns.TypeA, authoritative: true, answers: []string{"192.0.2.1"}, recorded: true},
+		{name: "configured record", qname: "www.example.com", qtype: dns.TypeA, authoritative: true, answers: []string{"192.0.2.10"}, recorded: true},
+		{name: "case is ignored", qname: "WWW.Example.COM", qtype: dns.TypeA, authoritative: true, answers: []string{"192.0.2.10"}, recorded: true},
+		{name: "wildcard", qname: "a.b.wild.example.com", qtype: dns.TypeTXT, authoritative: true, answers: []string{"wildcard"}, recorded: true},
+		{name: "exact name before wildcard", qname: "exact.wild.example.com", qtype: dns.TypeTXT, authoritative: true, answers: []string{"exact"}, recorded: true},
+		{name: "cname", qname: "alias.example.com", qtype: dns.TypeA, authoritative: true, answers: []string{"www.example.com."}, recorded: true},
+		{name: "apex soa", qname: "example.com", qtype: dns.TypeSOA, authoritative: true, answers: []string{"ns1.example.com."}, recorded: true},
+		{name: "apex ns", qname: "example.com", qtype: dns.TypeNS, authoritative: true, answers: []string{"ns1.example.com.", "ns2.example.com."}, recorded: true},
+		{
+			name: "nodata has negative soa", qname: "token.example.com", qtype: dns.TypeMX, authoritative: true,
+			negativeSOA: "example.com.", negativeTTL: negativeTTL, recorded: true,
+		},
+		{
+			name: "nodata of a configured type", qname: "www.example.com", qtype: dns.TypeAAAA, authoritative: true,
+			negativeSOA: "example.com.", negativeTTL: negativeTTL, recorded: true,
+		},
+		{name: "zone of a soa record", qname: "host.other.test", qtype: dns.TypeA, authoritative: true, answers: []string{"192.0.2.20"}, recorded: true},
+		{
+			name: "nodata in the zone of a soa record", qname: "host.other.test", qtype: dns.TypeTXT, authoritative: true,
+			negativeSOA: "other.test.", negativeTTL: 30, recorded: true,
+		},
+		{name: "foreign is refused", qname: "example.org", qtype: dns.TypeA, rcode: dns.RcodeRefused},
+		{
+			name: "foreign nxdomain", responder: DnsResponder{Foreign: DnsForeignNXDomain},
+			qname: "example.org", qtype: dns.TypeA, rcode: dns.RcodeNameError,
+		},
+		{name: "foreign dropped", responder: DnsResponder{Foreign: DnsForeignDrop}, qname: "example.org", qtype: dns.TypeA, dropped: true},
+		{
+			name: "foreign recorded", responder: DnsResponder{RecordForeign: true},
+			qname: "example.org", qtype: dns.TypeA, rcode: dns.RcodeRefused, recorded: true,
+		},
+		{
+			name: "no zones answer everything", responder: DnsResponder{Addresses: []net.IP{net.ParseIP("192.0.2.1")}, Zone: "-"},
+			qname: "example.org", qtype: dns.TypeA, answers: []string{"192.0.2.1"}, recorded: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := test.responder
+			if responder.Zone == "-" {
+				responder.Zone = ""
+			} else {
+				responder.Zone = "example.com"
+				responder.Records = records
+			}
+			responder.Addresses = []net.IP{net.ParseIP("192.0.2.1")}
+			answer, details, err := exchangeDns(t, &responder, test.qname, test.qtype)
+			if recorded := err == nil && details != nil; recorded != test.recorded {
+				t.Errorf("recorded = %v (%v), want %v", recorded, err, test.recorded)
+			}
+			if !test.recorded && err != ErrNotRecorded {
+				t.Errorf("error = %v, want ErrNotRecorded", err)
+			}
+			if test.dropped {
+				if answer != nil {
+					t.Errorf("answer %v, want none", answer)
+				}
+				return
+			}
+			if answer == nil {
+				t.Fatal("no answer")
+			}
+			if answer.Rcode != test.rcode || answer.Authoritative != test.authoritative {
+				t.Errorf("rcode %s, authoritative %v; want %s, %v", dns.RcodeToString[answer.Rcode],
+					answer.Authoritative, dns.RcodeToString[test.rcode], test.authoritative)
+			}
+			if len(answer.Answer) != len(test.answers) {
+				t.Fatalf("answers %v, want %v", answer.Answer, test.answers)
+			}
+			for i, rr := range answer.Answer {
+				if value := rrValue(rr); value != test.answers[i] {
+					t.Errorf("answer %d = %q, want %q", i+1, value, test.answers[i])
+				}
+				if !strings.EqualFold(rr.Header().Name, dns.Fqdn(test.qname)) {
+					t.Errorf("answer %d has name %s, want %s", i+1, rr.Header().Name, dns.Fqdn(test.qname))
+				}
+			}
+			if test.negativeSOA == "" {
+				if len(answer.Ns) != 0 {
+					t.Errorf("authority %v, want none", answer.Ns)
+				}
+				return
+			}
+			if len(answer.Ns) != 1 {
+				t.Fatalf("authority %v, want the soa of %s", answer.Ns, test.negativeSOA)
+			}
+			soa, ok := answer.Ns[0].(*dns.SOA)
+			if !ok || soa.Hdr.Name != test.negativeSOA || soa.Hdr.Ttl != test.negativeTTL {
+				t.Errorf("authority %v, want the soa of %s with ttl %d", answer.Ns[0], test.negativeSOA, test.negativeTTL)
+			}
+		})
+	}
+}
+
+func rrValue(rr dns.RR) string {
+	switch rr := rr.(type) {
+	case *dns.A:
+		return rr.A.String()
+	case *dns.TXT:
+		if len(rr.Txt) == 1 {
+			return rr.Txt[0]
+		}
+	case *dns.CNAME:
+		return rr.Target
+	case *dns.SOA:
+		return rr.Ns
+	case *dns.NS:
+		return rr.Ns
+	}
+	return rr.String()
+}
+
+func TestReadZoneFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		zone    string
+		include string
+		records []string
+		invalid bool
+	}{
+		{
+			name:    "relative names",
+			zone:    "$TTL 300\n@ IN MX 10 mail\nmail IN A 192.0.2.25\nwww IN CNAME @\n",
+			records: []string{"d.example.\t300\tIN\tMX\t10 mail.d.example.", "mail.d.example.\t300\tIN\tA\t192.0.2.25", "www.d.example.\t300\tIN\tCNAME\td.example."},
+		},
+		{
+			name:    "absolute names",
+			zone:    "other.example. 60 IN TXT \"other\"\n",
+			records: []string{"other.example.\t60\tIN\tTXT\t\"other\""},
+		},
+		{
+			name:    "origin",
+			zone:    "$ORIGIN sub.d.example.\nwww 60 IN A 192.0.2.1\n",
+			records: []string{"www.sub.d.example.\t60\tIN\tA\t192.0.2.1"},
+		},
+		{
+			name:    "include",
+			zone:    "$INCLUDE included.zone\n",
+			include: "www 60 IN A 192.0.2.1\n",
+			records: []string{"www.d.example.\t60\tIN\tA\t192.0.2.1"},
+		},
+		{name: "comments only", zone: "; nothing\n"},
+		{name: "invalid", zone: "www IN A not-an-ip\n", invalid: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			directory := t.TempDir()
+			path := filepath.Join(directory, "test.zone")
+			if err := ioutil.WriteFile(path, []byte(test.zone), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if test.include != "" {
+				if err := ioutil.WriteFile(filepath.Join(directory, "included.zone"), []byte(test.include), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			records, err := ReadZoneFile(path, "d.example")
+			if test.invalid {
+				if err == nil {
+					t.Errorf("read %v from invalid zone", records)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, record := range records {
+				got = append(got, record.String())
+			}
+			if strings.Join(got, "\n") != strings.Join(test.records, "\n") {
+				t.Errorf("records\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(test.records, "\n"))
+			}
+		})
+	}
+}
+
+func TestReadZoneFileMissing(t *testing.T) {
+	if _, err := ReadZoneFile(filepath.Join(t.TempDir(), "missing.zone"), "d.example"); err == nil {
+		t.Error("missing zone file read")
+	}
+}
@@ -0,0 +1,312 @@
+package ohren
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const RequestTypeGrpc = RequestType("gRPC call")
+
+const (
+	// GrpcStatusUnimplemented is the status code returned for unknown methods
+	GrpcStatusUnimplemented = 12
+	// maxGrpcMessages is the maximum amount of messages read from a call
+	maxGrpcMessages = 100
+	// defaultGrpcReadTimeout is the time to wait for the next message of a call
+	defaultGrpcReadTimeout = 2 * time.Second
+)
+
+// GrpcMessage is a length-prefixed message of a gRPC call.
+type GrpcMessage struct {
+	Compressed bool
+	// Data contains the raw (possibly compressed) protobuf message
+	Data []byte
+	// Decoded is the message as JSON if the method is known
+	Decoded string
+	// DecodeError describes why the message could not be decoded
+	DecodeError string
+}
+
+// GrpcRequestDetails are the details of a gRPC call.
+type GrpcRequestDetails struct {
+	*HttpRequestDetails
+	// Method is the full method name, e.g. "/package.Service/Method"
+	Method string
+	// Metadata are the request headers which are no reserved gRPC headers
+	Metadata http.Header
+	Messages []*GrpcMessage
+	// Status is the returned gRPC status code
+	Status        int
+	StatusMessage string
+}
+
+func (d GrpcRequestDetails) Type() RequestType {
+	return RequestTypeGrpc
+}
+
+func (d GrpcRequestDetails) Describe() string {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "> Method: %s\n", d.Method)
+	fmt.Fprintf(buffer, "> Status: %d %s\n", d.Status, d.StatusMessage)
+	buffer.WriteString("> Metadata:\n")
+	d.Metadata.Write(buffer)
+	for i, message := range d.Messages {
+		fmt.Fprintf(buffer, "> Message %d (%d bytes", i+1, len(message.Data))
+		if message.Compressed {
+			buffer.WriteString(", compressed")
+		}
+		buffer.WriteString("):\n")
+		if message.Decoded != "" {
+			buffer.WriteString(message.Decoded)
+			buffer.WriteString("\n")
+		} else if message.DecodeError != "" {
+			fmt.Fprintf(buffer, "[not decoded: %s]\n", message.DecodeError)
+		}
+		buffer.WriteString(hex.Dump(message.Data))
+	}
+	buffer.WriteString("\n")
+	buffer.WriteString(d.HttpRequestDetails.Describe())
+	return buffer.String()
+}
+
+// IsGrpcRequest returns true if the request is a gRPC call.
+func IsGrpcRequest(r *http.Request) bool {
+	return r.ProtoMajor >= 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// GrpcHandler answers gRPC calls with a fixed status and records the method,
+// the metadata and the messages. Other requests are passed to the Fallback.
+type GrpcHandler struct {
+	// Status is the returned gRPC status code (0 is OK)
+	Status  int
+	Message string
+	// Descriptors are used to decode the messages of known methods. May be nil.
+	Descriptors *protoregistry.Files
+	// ReadTimeout is the time to wait for the next message, defaults to 2s
+	ReadTimeout time.Duration
+	// MaxMessageSize limits the size of a message, defaults to DefaultMaxBodySize
+	MaxMessageSize int64
+	Fallback       http.Handler
+}
+
+func (g *GrpcHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !IsGrpcRequest(r) {
+		if g.Fallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		g.Fallback.ServeHTTP(w, r)
+		return
+	}
+	messages, err := g.readMessages(w, r)
+	if err != nil {
+		AddHttpNote(w, "reading grpc messages failed: "+err.Error())
+	}
+	inputType := g.inputType(r.URL.Path)
+	for _, message := range messages {
+		g.decode(message, inputType, r.Header.Get("Grpc-Encoding"))
+	}
+	WrapHttpDetails(w, func(details *HttpRequestDetails) RequestDetails {
+		return &GrpcRequestDetails{
+			HttpRequestDetails: details,
+			Method:             r.URL.Path,
+			Metadata:           grpcMetadata(r.Header),
+			Messages:           messages,
+			Status:             g.Status,
+			StatusMessage:      g.Message,
+		}
+	})
+
+	w.Header().Set("Content-Type", "application/grpc")
+	if g.Status != 0 {
+		// trailers-only response
+		w.Header().Set("Grpc-Status", strconv.Itoa(g.Status))
+		if g.Message != "" {
+			w.Header().Set("Grpc-Message", encodeGrpcMessage(g.Message))
+		}
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	w.Header().Set("Trailer", "Grpc-Status")
+	w.WriteHeader(http.StatusOK)
+	// an empty message is valid for every message type
+	_, _ = w.Write([]byte{0, 0, 0, 0, 0})
+	w.Header().Set("Grpc-Status", "0")
+}
+
+// encodeGrpcMessage percent-encodes the status message as required by the gRPC
+// protocol, like grpc-go does. Only printable ASCII characters except "%" are kept.
+func encodeGrpcMessage(message string) string {
+	var builder strings.Builder
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if c >= ' ' && c <= '~' && c != '%' {
+			builder.WriteByte(c)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", c)
+		}
+	}
+	return builder.String()
+}
+
+// readMessages reads the length-prefixed messages until the client closes the
+// stream, ReadTimeout passes without a message or maxGrpcMessages were read.
+func (g *GrpcHandler) readMessages(w http.ResponseWriter, r *http.Request) ([]*GrpcMessage, error) {
+	maxSize := g.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	readTimeout := g.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = defaultGrpcReadTimeout
+	}
+	controller := http.NewResponseController(w)
+	var messages []*GrpcMessage
+	for len(messages) < maxGrpcMessages {
+		// streams may stay open, the deadline is not supported by all protocols
+		_ = controller.SetReadDeadline(time.Now().Add(readTimeout))
+		message, err := readGrpcMessage(r.Body, maxSize)
+		if err == io.EOF || errors.Is(err, os.ErrDeadlineExceeded) {
+			return messages, nil
+		}
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, message)
+	}
+	return messages, nil
+}
+
+func readGrpcMessage(reader io.Reader, maxSize int64) (*GrpcMessage, error) {
+	prefix := make([]byte, 5)
+	if _, err := io.ReadFull(reader, prefix); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated message prefix")
+		}
+		return nil, err
+	}
+	length := int64(binary.BigEndian.Uint32(prefix[1:]))
+	if length > maxSize {
+		return nil, fmt.Errorf("message of %d bytes exceeds the limit", length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, fmt.Errorf("truncated message: %s", err)
+	}
+	return &GrpcMessage{
+		Compressed: prefix[0] == 1,
+		Data:       data,
+	}, nil
+}
+
+// inputType returns the input message of the method or nil if it is unknown.
+func (g *GrpcHandler) inputType(method string) protoreflect.MessageDescriptor {
+	if g.Descriptors == nil {
+		return nil
+	}
+	service, name, ok := splitGrpcMethod(method)
+	if !ok {
+		return nil
+	}
+	descriptor, err := g.Descriptors.FindDescriptorByName(service)
+	if err != nil {
+		return nil
+	}
+	serviceDescriptor, ok := descriptor.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return nil
+	}
+	methodDescriptor := serviceDescriptor.Methods().ByName(name)
+	if methodDescriptor == nil {
+		return nil
+	}
+	return methodDescriptor.Input()
+}
+
+func (g *GrpcHandler) decode(message *GrpcMessage, inputType protoreflect.MessageDescriptor, encoding string) {
+	if inputType == nil {
+		return
+	}
+	data := message.Data
+	if message.Compressed {
+		if encoding != "gzip" {
+			message.DecodeError = "unsupported encoding: " + encoding
+			return
+		}
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			message.DecodeError = err.Error()
+			return
+		}
+		data, err = ioutil.ReadAll(io.LimitReader(reader, DefaultMaxBodySize))
+		if err != nil {
+			message.DecodeError = err.Error()
+			return
+		}
+	}
+	decoded := dynamicpb.NewMessage(inputType)
+	if err := proto.Unmarshal(data, decoded); err != nil {
+		message.DecodeError = err.Error()
+		return
+	}
+	json, err := protojson.Marshal(decoded)
+	if err != nil {
+		message.DecodeError = err.Error()
+		return
+	}
+	message.Decoded = string(json)
+}
+
+// ReadDescriptorSet reads a serialized FileDescriptorSet as written by
+// "protoc --include_imports --descriptor_set_out".
+func ReadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := new(descriptorpb.FileDescriptorSet)
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, err
+	}
+	return protodesc.NewFiles(set)
+}
+
+// splitGrpcMethod splits "/package.Service/Method" into the service and the method name.
+func splitGrpcMethod(method string) (protoreflect.FullName, protoreflect.Name, bool) {
+	parts := strings.Split(strings.TrimPrefix(method, "/"), "/")
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	return protoreflect.FullName(parts[0]), protoreflect.Name(parts[1]), true
+}
+
+// grpcMetadata returns the headers without the reserved gRPC headers.
+func grpcMetadata(header http.Header) http.Header {
+	metadata := make(http.Header)
+	for name, values := range header {
+		switch strings.ToLower(name) {
+		case "content-type", "te", "grpc-encoding", "grpc-accept-encoding", "grpc-timeout":
+			continue
+		}
+		metadata[name] = values
+	}
+	return metadata
+}
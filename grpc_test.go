@@ -0,0 +1,64 @@
+package ohren
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestEncodeGrpcMessage(t *testing.T) {
+	tests := []struct {
+		message string
+		encoded string
+	}{
+		{message: "", encoded: ""},
+		{message: "unknown service", encoded: "unknown service"},
+		{message: "100%", encoded: "100%25"},
+		{message: "line\nbreak\r", encoded: "line%0Abreak%0D"},
+		{message: "tab\tend~", encoded: "tab%09end~"},
+		{message: "grüße", encoded: "gr%C3%BC%C3%9Fe"},
+		{message: "\x7f", encoded: "%7F"},
+	}
+	for _, test := range tests {
+		t.Run(test.message, func(t *testing.T) {
+			encoded := encodeGrpcMessage(test.message)
+			if encoded != test.encoded {
+				t.Errorf("encoded %q, want %q", encoded, test.encoded)
+			}
+			// clients decode the message like a query value without "+"
+			if decoded, err := url.PathUnescape(encoded); err != nil || decoded != test.message {
+				t.Errorf("decoded %q: %v", decoded, err)
+			}
+		})
+	}
+}
+
+func TestGrpcHandlerStatus(t *testing.T) {
+	handler := &GrpcHandler{Status: GrpcStatusUnimplemented, Message: "unknown\nservice 100%"}
+	body := []byte{0, 0, 0, 0, 2, 0x08, 0x01}
+	request := httptest.NewRequest(http.MethodPost, "/package.Service/Method", bytes.NewReader(body))
+	request.ProtoMajor = 2
+	request.Header.Set("Content-Type", "application/grpc")
+	recorder := httptest.NewRecorder()
+	details := ServeRecordedHttp(handler, recorder, request, 0)
+	if status := recorder.Header().Get("Grpc-Status"); status != "12" {
+		t.Errorf("Grpc-Status %q", status)
+	}
+	if message := recorder.Header().Get("Grpc-Message"); message != "unknown%0Aservice 100%25" {
+		t.Errorf("Grpc-Message %q", message)
+	}
+	grpcDetails, ok := details.(*GrpcRequestDetails)
+	if !ok {
+		t.Fatalf("recorded %T", details)
+	}
+	if grpcDetails.Method != "/package.Service/Method" || len(grpcDetails.Messages) != 1 ||
+		!bytes.Equal(grpcDetails.Messages[0].Data, body[5:]) {
+		t.Errorf("recorded %s with %d messages", grpcDetails.Method, len(grpcDetails.Messages))
+	}
+	// the recorded message is not encoded
+	if grpcDetails.StatusMessage != handler.Message {
+		t.Errorf("recorded status message %q", grpcDetails.StatusMessage)
+	}
+}
@@ -1,12 +1,10 @@
 package ohren
 
 import (
-	"bufio"
-	"bytes"
 	"crypto/tls"
 	"fmt"
+	"golang.org/x/net/http2"
 	"io"
-	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
@@ -15,7 +13,7 @@ import (
 
 var (
 	http1Regex = regexp.MustCompile("HTTP/(1|1.1)\r?\n?$")
-	http2Regex = regexp.MustCompile("HTTP/2\r?\n?$")
+	http2Regex = regexp.MustCompile("HTTP/2(\\.0)?\r?\n?$")
 )
 
 type HttpType string
@@ -23,6 +21,7 @@ type HttpType string
 const (
 	HttpTypeUnknown HttpType = "Unknown"
 	HttpTypePlain1           = "HTTP"
+	HttpTypePlain2           = "HTTP/2"
 	HttpTypeTls              = "HTTP+TLS"
 )
 
@@ -59,40 +58,26 @@ func getHttpProtocol(head []byte) int {
 type HtmlResponder struct {
 	ResponseContent string
 	ContentType     string
+	// MaxBodySize is the maximum amount of bytes captured of a request body.
+	// Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
 }
 
 func (r *HtmlResponder) Respond(conn net.Conn) (RequestDetails, error) {
-	var err error
-	var request *http.Request
-	request, err = http.ReadRequest(bufio.NewReaderSize(conn, 2048))
-	if err != nil {
-		return nil, err
-	}
-	_, err = io.Copy(io.Discard, request.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read body: %s", err)
-	}
-	bodyBytes := []byte(r.ResponseContent)
-	response := &http.Response{
-		StatusCode:   200,
-		Status:       "OK",
-		Close:        true,
-		Uncompressed: true,
-		Proto:        request.Proto,
-		ProtoMajor:   request.ProtoMajor,
-		ProtoMinor:   request.ProtoMinor,
-		Header: http.Header{
-			"Content-Type": []string{r.ContentType},
-		},
-		ContentLength: int64(len(bodyBytes)),
-		Body:          ioutil.NopCloser(bytes.NewReader(bodyBytes)),
+	responder := &HttpHandlerResponder{
+		Handler:     r.Handler(),
+		MaxBodySize: r.MaxBodySize,
 	}
+	return responder.Respond(conn)
+}
 
-	err = response.Write(conn)
-	return &HttpRequestDetails{
-		Request:  request,
-		Response: response,
-	}, err
+// Handler returns a handler responding with the content.
+func (r *HtmlResponder) Handler() *HttpResponse {
+	return &HttpResponse{
+		StatusCode:  http.StatusOK,
+		ContentType: r.ContentType,
+		Body:        []byte(r.ResponseContent),
+	}
 }
 
 func detectHttpProtocol(reader io.Reader) (HttpType, error) {
@@ -116,6 +101,9 @@ func detectHttpProtocol(reader io.Reader) (HttpType, error) {
 				if httpProtocol == 1 {
 					return HttpTypePlain1, nil
 				}
+				if httpProtocol == 2 {
+					return HttpTypePlain2, nil
+				}
 			}
 		} else {
 			log.Println("no newline")
@@ -127,37 +115,70 @@ func detectHttpProtocol(reader io.Reader) (HttpType, error) {
 type MultiHttpResponder struct {
 	HttpResponder  Responder
 	HttpsResponder Responder
+	// Http2Responder serves h2c connections and h2 if it is negotiated by ALPN
+	Http2Responder Responder
 }
 
+// WithTlsConfig returns a responder which serves TLS connections with the
+// config. h2 is offered by ALPN if the responder has an Http2Responder.
 func (m MultiHttpResponder) WithTlsConfig(config *tls.Config) *MultiHttpResponder {
+	tlsResponder := &TlsResponder{
+		Config:         config,
+		PlainResponder: m.HttpResponder,
+	}
+	if m.Http2Responder != nil {
+		tlsResponder.Config = config.Clone()
+		tlsResponder.Config.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+		tlsResponder.ProtocolResponders = map[string]Responder{
+			http2.NextProtoTLS: m.Http2Responder,
+		}
+	}
 	return &MultiHttpResponder{
 		HttpResponder:  m.HttpResponder,
-		HttpsResponder: &TlsResponder{
-			Config:         config,
-			PlainResponder: m.HttpResponder,
-		},
+		HttpsResponder: tlsResponder,
+		Http2Responder: m.Http2Responder,
 	}
 }
 
 func (m MultiHttpResponder) Respond(conn net.Conn) (RequestDetails, error) {
+	responder, resetConn, err := m.detectResponder(conn)
+	if err != nil {
+		return nil, err
+	}
+	return responder.Respond(resetConn)
+}
+
+func (m MultiHttpResponder) RespondStream(conn net.Conn, emit EmitFunc) error {
+	responder, resetConn, err := m.detectResponder(conn)
+	if err != nil {
+		return err
+	}
+	return RespondStream(responder, resetConn, emit)
+}
+
+// detectResponder returns the responder for the protocol used on the connection and
+// the connection to pass to it.
+func (m MultiHttpResponder) detectResponder(conn net.Conn) (Responder, net.Conn, error) {
 	resetConn := newResetConn(conn)
 	var responder Responder
 	protocol, err := detectHttpProtocol(resetConn)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	err = resetConn.Reset()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	switch protocol {
 	case HttpTypePlain1:
 		responder = m.HttpResponder
+	case HttpTypePlain2:
+		responder = m.Http2Responder
 	case HttpTypeTls:
 		responder = m.HttpsResponder
 	}
 	if responder == nil {
-		return nil, fmt.Errorf("no responder for protocol: %s", protocol)
+		return nil, nil, fmt.Errorf("no responder for protocol: %s", protocol)
 	}
-	return responder.Respond(resetConn)
+	return responder, resetConn, nil
 }
@@ -0,0 +1,58 @@
+package ohren
+
+import (
+	"golang.org/x/net/http2"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Http2Responder serves HTTP/2 connections negotiated by ALPN (h2) or started
+// with the connection preface (h2c with prior knowledge). Every stream is recorded.
+type Http2Responder struct {
+	Handler http.Handler
+	// MaxBodySize is the maximum amount of bytes captured of the request and
+	// response bodies. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+	// IdleTimeout closes connections without active streams. Defaults to
+	// DefaultKeepAliveTimeout.
+	IdleTimeout time.Duration
+}
+
+// Respond serves the connection and returns the details of the first stream.
+func (h *Http2Responder) Respond(conn net.Conn) (RequestDetails, error) {
+	var mutex sync.Mutex
+	var first RequestDetails
+	err := h.RespondStream(conn, func(details RequestDetails, _ time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if first == nil {
+			first = details
+		}
+	})
+	return first, err
+}
+
+// RespondStream serves the connection until it is closed or idle. Streams are
+// served concurrently, so emit may be called concurrently.
+func (h *Http2Responder) RespondStream(conn net.Conn, emit EmitFunc) error {
+	idleTimeout := h.IdleTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = DefaultKeepAliveTimeout
+	}
+	server := &http2.Server{
+		IdleTimeout: idleTimeout,
+	}
+	// the server reads the preface with its own timeout
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+	server.ServeConn(conn, &http2.ServeConnOpts{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			emit(ServeRecordedHttp(h.Handler, w, r, h.MaxBodySize), start)
+		}),
+	})
+	return nil
+}
@@ -0,0 +1,82 @@
+package ohren
+
+import (
+	"context"
+	"crypto/tls"
+	"golang.org/x/net/http2"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestMultiHttpResponderProtocols(t *testing.T) {
+	certificate, pool := newTestCertificate(t)
+	handler := writeHandler("path ")
+	responder := MultiHttpResponder{
+		HttpResponder:  &HttpHandlerResponder{Handler: handler},
+		Http2Responder: &Http2Responder{Handler: handler},
+	}.WithTlsConfig(&tls.Config{Certificates: []tls.Certificate{certificate}})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	records := make(chan Record, 10)
+	go func() {
+		_ = TcpListener{Listener: listener, Responder: responder}.Record(records)
+	}()
+	addr := listener.Addr().String()
+	tlsConfig := &tls.Config{RootCAs: pool}
+
+	tests := []struct {
+		name      string
+		transport http.RoundTripper
+		scheme    string
+		proto     string
+		protocol  string
+	}{
+		{name: "HTTP/1.1", transport: &http.Transport{}, scheme: "http", proto: "HTTP/1.1", protocol: "HTTP/1.1"},
+		{name: "HTTP/1.1 over TLS", transport: &http.Transport{TLSClientConfig: tlsConfig}, scheme: "https", proto: "HTTP/1.1", protocol: "HTTP/1.1+TLS"},
+		{name: "h2", transport: &http2.Transport{TLSClientConfig: tlsConfig}, scheme: "https", proto: "HTTP/2.0", protocol: "h2"},
+		{
+			name: "h2c with prior knowledge",
+			transport: &http2.Transport{
+				AllowHTTP: true,
+				DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+					var dialer net.Dialer
+					return dialer.DialContext(ctx, network, addr)
+				},
+			},
+			scheme:   "http",
+			proto:    "HTTP/2.0",
+			protocol: "h2c",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &http.Client{Transport: test.transport, Timeout: 5 * time.Second}
+			response, err := client.Get(test.scheme + "://" + addr + "/a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			response.Body.Close()
+			if response.Proto != test.proto || string(body) != "path /a" {
+				t.Errorf("response %s %q", response.Proto, body)
+			}
+			record := <-records
+			details, ok := record.Details.(*HttpRequestDetails)
+			if !ok {
+				t.Fatalf("recorded %T", record.Details)
+			}
+			if details.Protocol != test.protocol || details.Request.URL.Path != "/a" {
+				t.Errorf("recorded %s %s, want %s", details.Protocol, details.Request.URL.Path, test.protocol)
+			}
+			if closer, ok := test.transport.(interface{ CloseIdleConnections() }); ok {
+				closer.CloseIdleConnections()
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package ohren
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Http3Listener terminates QUIC on a UDP address and serves HTTP/3 with the
+// handler. Every request is recorded, the requests of a QUIC connection share
+// the ConnectionID.
+type Http3Listener struct {
+	Addr      string
+	Handler   http.Handler
+	TLSConfig *tls.Config
+	// MaxBodySize is the maximum amount of bytes captured of the request and
+	// response bodies. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+}
+
+type quicConnectionKey struct{}
+
+// quicConnection numbers the requests of a QUIC connection.
+type quicConnection struct {
+	id    string
+	mutex sync.Mutex
+	count int
+}
+
+func (c *quicConnection) next() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.count++
+	return c.count
+}
+
+func (l Http3Listener) Record(out chan Record) error {
+	conn, err := net.ListenPacket("udp", l.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(l.TLSConfig),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			out <- l.record(w, r)
+		}),
+		ConnContext: func(ctx context.Context, _ quic.Connection) context.Context {
+			return context.WithValue(ctx, quicConnectionKey{}, &quicConnection{id: newConnectionID()})
+		},
+	}
+	return server.Serve(conn)
+}
+
+func (l Http3Listener) record(w http.ResponseWriter, r *http.Request) Record {
+	record := new(RecordedConnection)
+	record.StartTime = time.Now()
+	record.Details = ServeRecordedHttp(l.Handler, w, r, l.MaxBodySize)
+	record.EndTime = time.Now()
+	if addr, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		record.SetLocalAddress(addr)
+	}
+	if addr, ok := r.Context().Value(http3.RemoteAddrContextKey).(net.Addr); ok {
+		record.SetRemoteAddress(addr)
+	}
+	if connection, ok := r.Context().Value(quicConnectionKey{}).(*quicConnection); ok {
+		record.ConnectionID = connection.id
+		record.RequestNumber = connection.next()
+	}
+	return record
+}
@@ -0,0 +1,96 @@
+package ohren
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/quic-go/quic-go/http3"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestCertificate returns a self-signed certificate for 127.0.0.1.
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "ohren test"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(certificate)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// freeUdpAddress returns a local UDP address which is currently not used.
+func freeUdpAddress(t *testing.T) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().String()
+}
+
+func TestHttp3Listener(t *testing.T) {
+	certificate, pool := newTestCertificate(t)
+	addr := freeUdpAddress(t)
+	listener := Http3Listener{
+		Addr:      addr,
+		Handler:   writeHandler("path "),
+		TLSConfig: &tls.Config{Certificates: []tls.Certificate{certificate}},
+	}
+	records := make(chan Record, 10)
+	go func() {
+		_ = listener.Record(records)
+	}()
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	for i, path := range []string{"/first", "/second"} {
+		response, err := client.Get("https://" + addr + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.ProtoMajor != 3 || string(body) != "path "+path {
+			t.Errorf("response %s %q", response.Proto, body)
+		}
+		record := <-records
+		details, ok := record.Details.(*HttpRequestDetails)
+		if !ok {
+			t.Fatalf("recorded %T", record.Details)
+		}
+		if details.Protocol != "h3" || details.Request.URL.Path != path || details.Response.StatusCode != http.StatusOK {
+			t.Errorf("recorded %s %s with status %d", details.Protocol, details.Request.URL.Path, details.Response.StatusCode)
+		}
+		// both requests use the same QUIC connection
+		if record.RequestNumber != i+1 || record.ConnectionID == "" || record.RemoteAddress != "127.0.0.1" {
+			t.Errorf("request %d of connection %q from %s", record.RequestNumber, record.ConnectionID, record.RemoteAddress)
+		}
+	}
+}
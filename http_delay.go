@@ -0,0 +1,181 @@
+package ohren
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HttpDelay slows down the response of the handler to measure how long clients
+// wait, e.g. to find request timeouts or to confirm blind SSRF by timing. The
+// time the client waited is added as note.
+type HttpDelay struct {
+	Handler http.Handler
+	// Delay is waited before the handler is called
+	Delay time.Duration
+	// Trickle is waited before each byte of the response body is sent
+	Trickle time.Duration
+	// Hang holds the request without responding and closes the connection
+	// afterwards. The other fields are ignored if it is set.
+	Hang time.Duration
+}
+
+func (d *HttpDelay) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// the body must be read before the connection can be watched
+	_, _ = io.Copy(ioutil.Discard, r.Body)
+	start := time.Now()
+	gone, stop := clientGone(w, r)
+	defer close(stop)
+	if d.Hang > 0 {
+		d.hang(w, start, gone)
+		return
+	}
+	if d.Delay > 0 {
+		timer := time.NewTimer(d.Delay)
+		select {
+		case <-gone:
+			timer.Stop()
+			AddHttpNote(w, fmt.Sprintf("delay: client gave up after %s", roundWait(time.Since(start))))
+			return
+		case <-timer.C:
+		}
+	}
+	if d.Trickle > 0 {
+		trickle := &trickleWriter{
+			writer:   w,
+			interval: d.Trickle,
+			gone:     gone,
+		}
+		d.Handler.ServeHTTP(trickle, r)
+		if trickle.aborted {
+			AddHttpNote(w, fmt.Sprintf("delay: client gave up after %s", roundWait(time.Since(start))))
+			return
+		}
+	} else {
+		d.Handler.ServeHTTP(w, r)
+	}
+	AddHttpNote(w, fmt.Sprintf("delay: client waited %s", roundWait(time.Since(start))))
+}
+
+// hang waits until the client gives up or Hang passed and closes the connection
+// without a response if possible.
+func (d *HttpDelay) hang(w http.ResponseWriter, start time.Time, gone <-chan struct{}) {
+	timer := time.NewTimer(d.Hang)
+	defer timer.Stop()
+	select {
+	case <-gone:
+		AddHttpNote(w, fmt.Sprintf("hang: client gave up after %s", roundWait(time.Since(start))))
+		return
+	case <-timer.C:
+	}
+	AddHttpNote(w, fmt.Sprintf("hang: client still waiting after %s", roundWait(time.Since(start))))
+	if hijacker, ok := w.(http.Hijacker); ok {
+		if conn, _, err := hijacker.Hijack(); err == nil {
+			_ = conn.Close()
+			WrapHttpDetails(w, func(details *HttpRequestDetails) RequestDetails {
+				details.Dropped = true
+				return details
+			})
+			return
+		}
+	}
+	// streams of HTTP/2 and HTTP/3 can't be closed without a response
+	w.WriteHeader(http.StatusGatewayTimeout)
+}
+
+// clientGone returns a channel which is closed if the client cancels the request
+// or closes the connection. The watch ends when stop is closed.
+func clientGone(w http.ResponseWriter, r *http.Request) (<-chan struct{}, chan struct{}) {
+	gone := make(chan struct{})
+	stop := make(chan struct{})
+	var closed <-chan bool
+	if notifier, ok := w.(http.CloseNotifier); ok {
+		closed = notifier.CloseNotify()
+	}
+	go func() {
+		select {
+		case <-closed:
+			close(gone)
+		case <-r.Context().Done():
+			close(gone)
+		case <-stop:
+		}
+	}()
+	return gone, stop
+}
+
+func roundWait(duration time.Duration) time.Duration {
+	return duration.Round(time.Millisecond)
+}
+
+var errClientGone = errors.New("client closed the connection")
+
+// trickleWriter sends the body byte by byte.
+type trickleWriter struct {
+	writer   http.ResponseWriter
+	interval time.Duration
+	gone     <-chan struct{}
+	aborted  bool
+}
+
+func (t *trickleWriter) Header() http.Header {
+	return t.writer.Header()
+}
+
+// WriteHeader sends the headers immediately, only the body is slowed down.
+func (t *trickleWriter) WriteHeader(statusCode int) {
+	t.writer.WriteHeader(statusCode)
+	t.Flush()
+}
+
+func (t *trickleWriter) Write(p []byte) (int, error) {
+	if t.aborted {
+		return 0, errClientGone
+	}
+	for i := range p {
+		timer := time.NewTimer(t.interval)
+		select {
+		case <-t.gone:
+			timer.Stop()
+			t.aborted = true
+			return i, errClientGone
+		case <-timer.C:
+		}
+		if _, err := t.writer.Write(p[i : i+1]); err != nil {
+			return i, err
+		}
+		t.Flush()
+	}
+	return len(p), nil
+}
+
+func (t *trickleWriter) Flush() {
+	if flusher, ok := t.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (t *trickleWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := t.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	return hijacker.Hijack()
+}
+
+func (t *trickleWriter) Unwrap() http.ResponseWriter {
+	return t.writer
+}
+
+func (t *trickleWriter) AddNote(note string) {
+	AddHttpNote(t.writer, note)
+}
+
+func (t *trickleWriter) WrapDetails(wrap func(details *HttpRequestDetails) RequestDetails) {
+	WrapHttpDetails(t.writer, wrap)
+}
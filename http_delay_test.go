@@ -0,0 +1,106 @@
+package ohren
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHttpDelay(t *testing.T) {
+	tests := []struct {
+		name  string
+		delay *HttpDelay
+		// giveUp closes the connection after the duration instead of waiting for the response
+		giveUp  time.Duration
+		status  int
+		body    string
+		note    string
+		dropped bool
+		// minimum is the least time until the response is complete
+		minimum time.Duration
+	}{
+		{name: "delay", delay: &HttpDelay{Delay: 100 * time.Millisecond}, status: http.StatusOK, body: "abc", note: "delay: client waited", minimum: 100 * time.Millisecond},
+		{name: "trickle", delay: &HttpDelay{Trickle: 30 * time.Millisecond}, status: http.StatusOK, body: "abc", note: "delay: client waited", minimum: 90 * time.Millisecond},
+		{name: "delay given up", delay: &HttpDelay{Delay: time.Minute}, giveUp: 100 * time.Millisecond, note: "delay: client gave up"},
+		{name: "trickle given up", delay: &HttpDelay{Trickle: time.Minute}, giveUp: 100 * time.Millisecond, note: "delay: client gave up"},
+		{name: "hang", delay: &HttpDelay{Hang: 100 * time.Millisecond, Delay: time.Minute}, note: "hang: client still waiting", dropped: true, minimum: 100 * time.Millisecond},
+		{name: "hang given up", delay: &HttpDelay{Hang: time.Minute}, giveUp: 100 * time.Millisecond, note: "hang: client gave up"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.delay.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "3")
+				_, _ = w.Write([]byte("abc"))
+			})
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			records := make(chan Record, 1)
+			go func() {
+				_ = TcpListener{Listener: listener, Responder: &HttpHandlerResponder{Handler: test.delay}}.Record(records)
+			}()
+			conn, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			start := time.Now()
+			if _, err := conn.Write([]byte("GET / HTTP/1.1\r\nHost: x\r\n\r\n")); err != nil {
+				t.Fatal(err)
+			}
+			if test.giveUp > 0 {
+				time.Sleep(test.giveUp)
+				conn.Close()
+			} else {
+				if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+					t.Fatal(err)
+				}
+				response, err := http.ReadResponse(bufio.NewReader(conn), nil)
+				if test.status == 0 {
+					if err == nil {
+						t.Errorf("response %d to a hanging request", response.StatusCode)
+					}
+				} else {
+					if err != nil {
+						t.Fatal(err)
+					}
+					body, _ := ioutil.ReadAll(response.Body)
+					if response.StatusCode != test.status || string(body) != test.body {
+						t.Errorf("response %d %q", response.StatusCode, body)
+					}
+				}
+				if elapsed := time.Since(start); elapsed < test.minimum {
+					t.Errorf("complete after %s, want at least %s", elapsed, test.minimum)
+				}
+			}
+			var record Record
+			select {
+			case record = <-records:
+			case <-time.After(5 * time.Second):
+				t.Fatal("request not recorded")
+			}
+			details := record.Details.(*HttpRequestDetails)
+			if !hasNotePrefix(details.Notes, test.note) {
+				t.Errorf("notes %q, want %q", details.Notes, test.note)
+			}
+			if details.Dropped != test.dropped {
+				t.Errorf("dropped = %v", details.Dropped)
+			}
+		})
+	}
+}
+
+func hasNotePrefix(notes []string, prefix string) bool {
+	for _, note := range notes {
+		if strings.HasPrefix(note, prefix) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package ohren
+
+import (
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+// HttpDirectory serves the files in Root. Content types are detected by the
+// file extension and range requests are supported. Directories are only served
+// if they contain an index.html, there are no directory listings.
+type HttpDirectory struct {
+	Root string
+	// StripPrefix is removed from the path before the file is looked up
+	StripPrefix string
+}
+
+func (d *HttpDirectory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, d.StripPrefix)
+	AddHttpNote(w, "file: "+path.Clean("/"+name))
+	handler := http.FileServer(noListingFileSystem{http.Dir(d.Root)})
+	if d.StripPrefix != "" {
+		handler = http.StripPrefix(d.StripPrefix, handler)
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// noListingFileSystem hides directories without an index.html.
+type noListingFileSystem struct {
+	fs http.FileSystem
+}
+
+func (n noListingFileSystem) Open(name string) (http.File, error) {
+	file, err := n.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		index, err := n.fs.Open(path.Join(name, "index.html"))
+		if err != nil {
+			_ = file.Close()
+			return nil, os.ErrNotExist
+		}
+		_ = index.Close()
+	}
+	return file, nil
+}
@@ -0,0 +1,96 @@
+package ohren
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestDirectory creates root/payloads with a file, a directory with and one
+// without an index.html and a secret file next to the payloads.
+func newTestDirectory(t *testing.T) string {
+	root := t.TempDir()
+	files := map[string]string{
+		"secret":                     "secret",
+		"payloads/x.sh":              "id",
+		"payloads/list/a.txt":        "a",
+		"payloads/index/index.html":  "index",
+		"payloads/index/nested/b.js": "b",
+	}
+	for name, content := range files {
+		name = filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(root, "payloads")
+}
+
+func TestHttpDirectory(t *testing.T) {
+	handler := &HttpDirectory{Root: newTestDirectory(t), StripPrefix: "/p"}
+	tests := []struct {
+		name   string
+		target string
+		status int
+		body   string
+	}{
+		{name: "file", target: "/p/x.sh", status: http.StatusOK, body: "id"},
+		{name: "nested file", target: "/p/index/nested/b.js", status: http.StatusOK, body: "b"},
+		{name: "missing file", target: "/p/y.sh", status: http.StatusNotFound},
+		{name: "index", target: "/p/index/", status: http.StatusOK, body: "index"},
+		{name: "directory without index", target: "/p/list/", status: http.StatusNotFound},
+		{name: "directory without index nor slash", target: "/p/list", status: http.StatusNotFound},
+		{name: "root without index", target: "/p/", status: http.StatusNotFound},
+		{name: "traversal", target: "/p/../secret", status: http.StatusNotFound},
+		{name: "encoded traversal", target: "/p/%2e%2e/secret", status: http.StatusNotFound},
+		{name: "nested traversal", target: "/p/list/../../secret", status: http.StatusNotFound},
+		{name: "other prefix", target: "/q/x.sh", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d", w.Code, test.status)
+			}
+			if test.body != "" && w.Body.String() != test.body {
+				t.Errorf("body %q, want %q", w.Body.String(), test.body)
+			}
+		})
+	}
+}
+
+func TestNoListingFileSystem(t *testing.T) {
+	fs := noListingFileSystem{http.Dir(newTestDirectory(t))}
+	tests := []struct {
+		name   string
+		exists bool
+	}{
+		{name: "/x.sh", exists: true},
+		{name: "/index", exists: true},
+		{name: "/list", exists: false},
+		{name: "/", exists: false},
+		{name: "/../secret", exists: false},
+		{name: "/missing", exists: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file, err := fs.Open(test.name)
+			if err == nil {
+				_ = file.Close()
+			}
+			if exists := err == nil; exists != test.exists {
+				t.Errorf("exists = %v, want %v (%v)", exists, test.exists, err)
+			}
+			if !test.exists && !os.IsNotExist(err) {
+				t.Errorf("error %v, want not exist", err)
+			}
+		})
+	}
+}
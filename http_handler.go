@@ -0,0 +1,652 @@
+package ohren
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxBufferedResponse is the amount of bytes of a response body which are buffered
+// to calculate the content length before the response is sent chunked.
+const maxBufferedResponse = 64 * 1024
+
+// HttpNoter is implemented by response writers of recorded requests. The notes
+// are added to the HttpRequestDetails.
+type HttpNoter interface {
+	AddNote(note string)
+}
+
+// AddHttpNote adds the note to the details of the recorded request if w records it.
+func AddHttpNote(w http.ResponseWriter, note string) {
+	if noter, ok := w.(HttpNoter); ok {
+		noter.AddNote(note)
+	}
+}
+
+// HttpDetailsWrapper is implemented by response writers of recorded requests.
+// Handlers can record details of another type wrapping the HTTP details.
+type HttpDetailsWrapper interface {
+	WrapDetails(wrap func(details *HttpRequestDetails) RequestDetails)
+}
+
+// WrapHttpDetails records the details returned by wrap instead of the HTTP
+// details if w records the request.
+func WrapHttpDetails(w http.ResponseWriter, wrap func(details *HttpRequestDetails) RequestDetails) {
+	if wrapper, ok := w.(HttpDetailsWrapper); ok {
+		wrapper.WrapDetails(wrap)
+	}
+}
+
+const (
+	// DefaultMaxKeepAliveRequests is the default maximum amount of requests on a connection
+	DefaultMaxKeepAliveRequests = 100
+	// DefaultKeepAliveTimeout is the default time to wait for the next request on a connection
+	DefaultKeepAliveTimeout = 5 * time.Second
+	// DefaultMaxHeaderBytes is the default maximum size of the request line and the headers
+	DefaultMaxHeaderBytes = http.DefaultMaxHeaderBytes
+)
+
+// HttpHandlerResponder reads HTTP/1 requests and responds with the handler.
+type HttpHandlerResponder struct {
+	Handler http.Handler
+	// MaxBodySize is the maximum amount of bytes captured of the request and
+	// response bodies. Defaults to DefaultMaxBodySize.
+	MaxBodySize int64
+	// MaxRequests is the maximum amount of requests answered on a keep-alive
+	// connection. Defaults to DefaultMaxKeepAliveRequests.
+	MaxRequests int
+	// IdleTimeout is the time to wait for the next request on a keep-alive
+	// connection and to read the headers of a request. Defaults to
+	// DefaultKeepAliveTimeout.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes is the maximum size of the request line and the headers of
+	// a request. Larger requests are answered with status 431. Defaults to
+	// DefaultMaxHeaderBytes.
+	MaxHeaderBytes int
+}
+
+// Respond responds to a single request and closes the connection.
+func (h *HttpHandlerResponder) Respond(conn net.Conn) (RequestDetails, error) {
+	reader, limit := newHttp1Reader(conn)
+	details, _, err := h.serve(conn, reader, limit, true)
+	return details, err
+}
+
+// RespondStream responds to the requests on the connection until the client or
+// the handler closes it, the connection is idle or MaxRequests were answered.
+// Pipelined requests are answered in order.
+func (h *HttpHandlerResponder) RespondStream(conn net.Conn, emit EmitFunc) error {
+	maxRequests := h.MaxRequests
+	if maxRequests <= 0 {
+		maxRequests = DefaultMaxKeepAliveRequests
+	}
+	reader, limit := newHttp1Reader(conn)
+	for count := 1; ; count++ {
+		if count > 1 {
+			if err := conn.SetReadDeadline(time.Now().Add(h.idleTimeout())); err != nil {
+				return err
+			}
+			if _, err := reader.Peek(1); err != nil {
+				// the client closed the connection or was idle for too long
+				return nil
+			}
+		}
+		start := time.Now()
+		details, keepAlive, err := h.serve(conn, reader, limit, count >= maxRequests)
+		if details != nil {
+			emit(details, start)
+		}
+		if err != nil || !keepAlive {
+			return err
+		}
+	}
+}
+
+// newHttp1Reader returns a buffered reader of the connection and the limit of
+// the bytes read from the connection through it.
+func newHttp1Reader(conn net.Conn) (*bufio.Reader, *io.LimitedReader) {
+	limit := &io.LimitedReader{R: conn, N: math.MaxInt64}
+	return bufio.NewReaderSize(limit, 2048), limit
+}
+
+// serve responds to the next request from the reader and returns whether the
+// connection can be used for further requests. The limit is reset for the
+// headers of every request.
+func (h *HttpHandlerResponder) serve(conn net.Conn, reader *bufio.Reader, limit *io.LimitedReader, last bool) (RequestDetails, bool, error) {
+	// slow clients must not keep the connection open by sending the headers byte by byte
+	if err := conn.SetReadDeadline(time.Now().Add(h.idleTimeout())); err != nil {
+		return nil, false, err
+	}
+	// like http.Server the limit leaves room for the bytes buffered by the reader
+	limit.N = int64(h.maxHeaderBytes()) + 4096
+	request, err := http.ReadRequest(reader)
+	if err != nil {
+		if limit.N <= 0 {
+			status := http.StatusRequestHeaderFieldsTooLarge
+			_, _ = fmt.Fprintf(conn, "HTTP/1.1 %d %s\r\nContent-Type: text/plain; charset=utf-8\r\nConnection: close\r\n\r\n%d %s",
+				status, http.StatusText(status), status, http.StatusText(status))
+			return nil, false, fmt.Errorf("request headers exceed %d bytes", h.maxHeaderBytes())
+		}
+		return nil, false, err
+	}
+	limit.N = math.MaxInt64
+	if err := conn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, false, err
+	}
+	request.RemoteAddr = conn.RemoteAddr().String()
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		request.TLS = &state
+	}
+	writer := newHttp1ResponseWriter(conn, reader, request)
+	writer.close = writer.close || last || !request.ProtoAtLeast(1, 1)
+	if request.ProtoAtLeast(1, 1) && request.Body != http.NoBody &&
+		strings.EqualFold(request.Header.Get("Expect"), "100-continue") {
+		// the client waits for the interim response before it sends the body
+		request.Body = &expectContinueReader{body: request.Body, writer: writer}
+	}
+	details, recorder := serveRecordedHttp(h.Handler, writer, request, h.MaxBodySize)
+	if body, ok := request.Body.(*bodyRecorder); ok && body.readError != nil {
+		// the rest of the body can't be skipped
+		writer.close = true
+	}
+	err = writer.finish()
+	// the writer adds headers like Content-Length when the response is finished
+	details.Response.Header = writer.header.Clone()
+	if err != nil {
+		return recorder.wrap(details), false, err
+	}
+	return recorder.wrap(details), !writer.close && !writer.hijacked, nil
+}
+
+func (h *HttpHandlerResponder) maxHeaderBytes() int {
+	if h.MaxHeaderBytes <= 0 {
+		return DefaultMaxHeaderBytes
+	}
+	return h.MaxHeaderBytes
+}
+
+func (h *HttpHandlerResponder) idleTimeout() time.Duration {
+	if h.IdleTimeout <= 0 {
+		return DefaultKeepAliveTimeout
+	}
+	return h.IdleTimeout
+}
+
+// ServeRecordedHttp serves the request with the handler and records the request
+// and the response. The request body is captured up to maxBodySize bytes and
+// drained after the handler returned. The details are HttpRequestDetails unless
+// the handler wrapped them.
+func ServeRecordedHttp(handler http.Handler, w http.ResponseWriter, r *http.Request, maxBodySize int64) RequestDetails {
+	details, recorder := serveRecordedHttp(handler, w, r, maxBodySize)
+	return recorder.wrap(details)
+}
+
+func serveRecordedHttp(handler http.Handler, w http.ResponseWriter, r *http.Request, maxBodySize int64) (*HttpRequestDetails, *responseRecorder) {
+	body := newBodyRecorder(r.Body, maxBodySize)
+	r.Body = body
+	recorder := &responseRecorder{
+		writer:      w,
+		maxBodySize: maxBodySize,
+	}
+	handler.ServeHTTP(recorder, r)
+	if !recorder.hijacked {
+		// streams may stay open, the deadline is not supported by all protocols
+		_ = http.NewResponseController(w).SetReadDeadline(time.Now().Add(DefaultKeepAliveTimeout))
+		body.drain()
+	}
+	return &HttpRequestDetails{
+		Protocol:     HttpProtocol(r),
+		Request:      r,
+		Response:     recorder.response(r),
+		Body:         body.captured(),
+		ResponseBody: recorder.captured(),
+		Notes:        recorder.notes,
+	}, recorder
+}
+
+// HttpProtocol returns a label of the protocol of the request, e.g. "HTTP/1.1+TLS" or "h2c".
+func HttpProtocol(r *http.Request) string {
+	switch r.ProtoMajor {
+	case 2:
+		if r.TLS == nil {
+			return "h2c"
+		}
+		return "h2"
+	case 3:
+		return "h3"
+	}
+	if r.TLS != nil {
+		return r.Proto + "+TLS"
+	}
+	return r.Proto
+}
+
+// bodyRecorder captures the bytes read from a body.
+type bodyRecorder struct {
+	body      io.ReadCloser
+	buffer    bytes.Buffer
+	limit     int64
+	size      int64
+	readError error
+}
+
+func newBodyRecorder(body io.ReadCloser, limit int64) *bodyRecorder {
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	if body == nil {
+		body = http.NoBody
+	}
+	return &bodyRecorder{
+		body:  body,
+		limit: limit,
+	}
+}
+
+func (b *bodyRecorder) Read(p []byte) (n int, err error) {
+	n, err = b.body.Read(p)
+	b.size += int64(n)
+	if remaining := b.limit - int64(b.buffer.Len()); remaining > 0 {
+		if int64(n) < remaining {
+			remaining = int64(n)
+		}
+		b.buffer.Write(p[:remaining])
+	}
+	if err != nil && err != io.EOF {
+		b.readError = err
+	}
+	return
+}
+
+func (b *bodyRecorder) Close() error {
+	return b.body.Close()
+}
+
+func (b *bodyRecorder) drain() {
+	if b.readError == nil {
+		_, _ = io.Copy(ioutil.Discard, b)
+	}
+}
+
+func (b *bodyRecorder) captured() *CapturedBody {
+	return &CapturedBody{
+		Data:      b.buffer.Bytes(),
+		Size:      b.size,
+		Truncated: b.size > int64(b.buffer.Len()),
+	}
+}
+
+// expectContinueReader sends the interim response "100 Continue" before the
+// body is read the first time, like the reader of http.Server.
+type expectContinueReader struct {
+	body      io.ReadCloser
+	writer    *http1ResponseWriter
+	continued bool
+}
+
+func (r *expectContinueReader) Read(p []byte) (int, error) {
+	if !r.continued {
+		r.continued = true
+		if err := r.writer.writeContinue(); err != nil {
+			return 0, err
+		}
+	}
+	return r.body.Read(p)
+}
+
+func (r *expectContinueReader) Close() error {
+	return r.body.Close()
+}
+
+// responseRecorder captures the response written to a response writer.
+type responseRecorder struct {
+	writer      http.ResponseWriter
+	status      int
+	buffer      bytes.Buffer
+	size        int64
+	maxBodySize int64
+	hijacked    bool
+	notes       []string
+	wrapper     func(details *HttpRequestDetails) RequestDetails
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.writer.Header()
+}
+
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+	r.writer.WriteHeader(statusCode)
+}
+
+func (r *responseRecorder) Write(p []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.writer.Write(p)
+	limit := r.maxBodySize
+	if limit <= 0 {
+		limit = DefaultMaxBodySize
+	}
+	if remaining := limit - int64(r.buffer.Len()); remaining > 0 {
+		if int64(n) < remaining {
+			remaining = int64(n)
+		}
+		r.buffer.Write(p[:remaining])
+	}
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *responseRecorder) Flush() {
+	if flusher, ok := r.writer.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.writer.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijacking not supported")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err == nil {
+		r.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// CloseNotify forwards to the wrapped writer. The channel never receives a value
+// if the wrapped writer doesn't support it.
+func (r *responseRecorder) CloseNotify() <-chan bool {
+	if notifier, ok := r.writer.(http.CloseNotifier); ok {
+		return notifier.CloseNotify()
+	}
+	return make(chan bool)
+}
+
+// Unwrap allows http.ResponseController to access the wrapped writer.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.writer
+}
+
+func (r *responseRecorder) AddNote(note string) {
+	r.notes = append(r.notes, note)
+}
+
+func (r *responseRecorder) WrapDetails(wrap func(details *HttpRequestDetails) RequestDetails) {
+	r.wrapper = wrap
+}
+
+func (r *responseRecorder) wrap(details *HttpRequestDetails) RequestDetails {
+	if r.wrapper == nil {
+		return details
+	}
+	return r.wrapper(details)
+}
+
+func (r *responseRecorder) response(request *http.Request) *http.Response {
+	status := r.status
+	if status == 0 && !r.hijacked {
+		status = http.StatusOK
+	}
+	return &http.Response{
+		StatusCode:    status,
+		Status:        http.StatusText(status),
+		Proto:         request.Proto,
+		ProtoMajor:    request.ProtoMajor,
+		ProtoMinor:    request.ProtoMinor,
+		Header:        r.writer.Header().Clone(),
+		ContentLength: -1,
+	}
+}
+
+func (r *responseRecorder) captured() *CapturedBody {
+	return &CapturedBody{
+		Data:      r.buffer.Bytes(),
+		Size:      r.size,
+		Truncated: r.size > int64(r.buffer.Len()),
+	}
+}
+
+// http1ResponseWriter writes an HTTP/1 response to a connection. The body is
+// buffered to calculate the content length. Larger or flushed bodies are sent
+// chunked, or for HTTP/1.0 delimited by closing the connection.
+type http1ResponseWriter struct {
+	conn      net.Conn
+	reader    *bufio.Reader
+	writer    *bufio.Writer
+	request   *http.Request
+	header    http.Header
+	status    int
+	buffer    bytes.Buffer
+	committed bool
+	chunked   bool
+	hijacked  bool
+	// close is set if the connection is closed after the response
+	close bool
+	// closeNotify receives a value if the client closed the connection while
+	// closeWatcher is waiting for it
+	closeNotify  chan bool
+	closeWatcher chan struct{}
+}
+
+func newHttp1ResponseWriter(conn net.Conn, reader *bufio.Reader, request *http.Request) *http1ResponseWriter {
+	return &http1ResponseWriter{
+		conn:    conn,
+		reader:  reader,
+		writer:  bufio.NewWriter(conn),
+		request: request,
+		header:  make(http.Header),
+		close:   request.Close,
+	}
+}
+
+func (w *http1ResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *http1ResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 && !w.hijacked {
+		w.status = statusCode
+	}
+}
+
+func (w *http1ResponseWriter) bodyAllowed() bool {
+	return w.request.Method != http.MethodHead &&
+		w.status >= 200 && w.status != http.StatusNoContent && w.status != http.StatusNotModified
+}
+
+func (w *http1ResponseWriter) Write(p []byte) (int, error) {
+	if w.hijacked {
+		return 0, http.ErrHijacked
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.bodyAllowed() {
+		return len(p), nil
+	}
+	if !w.committed {
+		w.buffer.Write(p)
+		if w.buffer.Len() > maxBufferedResponse {
+			if err := w.commit(false); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	return w.writeBody(p)
+}
+
+func (w *http1ResponseWriter) writeBody(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if w.chunked {
+		if _, err := fmt.Fprintf(w.writer, "%x\r\n", len(p)); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.writer.Write(p)
+	if err != nil {
+		return n, err
+	}
+	if w.chunked {
+		_, err = w.writer.WriteString("\r\n")
+	}
+	return n, err
+}
+
+func (w *http1ResponseWriter) Flush() {
+	if w.hijacked {
+		return
+	}
+	if !w.committed {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		if err := w.commit(false); err != nil {
+			return
+		}
+	}
+	_ = w.writer.Flush()
+}
+
+func (w *http1ResponseWriter) SetReadDeadline(deadline time.Time) error {
+	return w.conn.SetReadDeadline(deadline)
+}
+
+// CloseNotify returns a channel which receives a value if the client closes the
+// connection. Like http.CloseNotifier it must not be called before the request
+// body was read.
+func (w *http1ResponseWriter) CloseNotify() <-chan bool {
+	if w.closeNotify != nil {
+		return w.closeNotify
+	}
+	w.closeNotify = make(chan bool, 1)
+	w.closeWatcher = make(chan struct{})
+	go func() {
+		defer close(w.closeWatcher)
+		// a pipelined request stays buffered for the next call of serve
+		if _, err := w.reader.Peek(1); err != nil && !isTimeout(err) {
+			w.closeNotify <- true
+		}
+	}()
+	return w.closeNotify
+}
+
+// stopCloseWatcher interrupts the goroutine of CloseNotify before the reader is
+// used again.
+func (w *http1ResponseWriter) stopCloseWatcher() {
+	if w.closeWatcher == nil {
+		return
+	}
+	_ = w.conn.SetReadDeadline(time.Now())
+	<-w.closeWatcher
+	_ = w.conn.SetReadDeadline(time.Time{})
+	w.closeWatcher = nil
+}
+
+func (w *http1ResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.hijacked {
+		return nil, nil, http.ErrHijacked
+	}
+	w.stopCloseWatcher()
+	if w.committed {
+		if err := w.writer.Flush(); err != nil {
+			return nil, nil, err
+		}
+	}
+	w.hijacked = true
+	return w.conn, bufio.NewReadWriter(w.reader, w.writer), nil
+}
+
+// writeContinue sends the interim response "100 Continue" unless the final
+// response was already started.
+func (w *http1ResponseWriter) writeContinue() error {
+	if w.committed || w.hijacked {
+		return nil
+	}
+	if _, err := w.writer.WriteString("HTTP/1.1 100 Continue\r\n\r\n"); err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// commit writes the status line, the headers and the buffered body.
+func (w *http1ResponseWriter) commit(complete bool) error {
+	w.committed = true
+	if w.header.Get("Content-Length") == "" && w.bodyAllowed() {
+		switch {
+		case complete:
+			w.header.Set("Content-Length", strconv.Itoa(w.buffer.Len()))
+		case w.request.ProtoAtLeast(1, 1):
+			w.chunked = true
+			w.header.Set("Transfer-Encoding", "chunked")
+		default:
+			w.close = true
+		}
+	}
+	if w.header.Get("Content-Type") == "" && w.buffer.Len() > 0 {
+		w.header.Set("Content-Type", http.DetectContentType(w.buffer.Bytes()))
+	}
+	if w.header.Get("Date") == "" {
+		w.header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	}
+	if w.close {
+		w.header.Set("Connection", "close")
+	}
+	proto := "HTTP/1.1"
+	if !w.request.ProtoAtLeast(1, 1) {
+		proto = "HTTP/1.0"
+	}
+	if _, err := fmt.Fprintf(w.writer, "%s %d %s\r\n", proto, w.status, http.StatusText(w.status)); err != nil {
+		return err
+	}
+	if err := w.header.Write(w.writer); err != nil {
+		return err
+	}
+	if _, err := w.writer.WriteString("\r\n"); err != nil {
+		return err
+	}
+	buffered := w.buffer.Bytes()
+	w.buffer = bytes.Buffer{}
+	_, err := w.writeBody(buffered)
+	return err
+}
+
+// finish completes the response after the handler returned.
+func (w *http1ResponseWriter) finish() error {
+	if w.hijacked {
+		return nil
+	}
+	w.stopCloseWatcher()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if !w.committed {
+		if err := w.commit(true); err != nil {
+			return err
+		}
+	}
+	if w.chunked {
+		if _, err := w.writer.WriteString("0\r\n\r\n"); err != nil {
+			return err
+		}
+	}
+	return w.writer.Flush()
+}
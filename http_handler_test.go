@@ -0,0 +1,379 @@
+package ohren
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type http1Response struct {
+	status           int
+	proto            string
+	header           http.Header
+	transferEncoding []string
+	// close is set if the response announced closing the connection
+	close bool
+	body  string
+}
+
+// serveHttp1 sends the raw requests on one connection and returns the responses
+// of the requests with the methods, whether the server closed the connection
+// afterwards and the recorded details.
+func serveHttp1(t *testing.T, responder *HttpHandlerResponder, raw string, methods ...string) ([]*http1Response, bool, []*HttpRequestDetails) {
+	t.Helper()
+	if responder.IdleTimeout == 0 {
+		responder.IdleTimeout = 200 * time.Millisecond
+	}
+	server, client := net.Pipe()
+	defer client.Close()
+	var mutex sync.Mutex
+	var details []*HttpRequestDetails
+	done := make(chan error, 1)
+	go func() {
+		err := responder.RespondStream(server, func(d RequestDetails, _ time.Time) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			details = append(details, d.(*HttpRequestDetails))
+		})
+		server.Close()
+		done <- err
+	}()
+	go func() {
+		_, _ = io.WriteString(client, raw)
+	}()
+	if err := client.SetDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	reader := bufio.NewReader(client)
+	var responses []*http1Response
+	for _, method := range methods {
+		response, err := http.ReadResponse(reader, &http.Request{Method: method})
+		if err != nil {
+			t.Fatalf("response %d: %s", len(responses)+1, err)
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			t.Fatalf("body of response %d: %s", len(responses)+1, err)
+		}
+		responses = append(responses, &http1Response{
+			status:           response.StatusCode,
+			proto:            response.Proto,
+			header:           response.Header,
+			transferEncoding: response.TransferEncoding,
+			close:            response.Close,
+			body:             string(body),
+		})
+	}
+	// the server closes the connection at the latest after the idle timeout
+	_, err := reader.ReadByte()
+	closed := err == io.EOF
+	if err == nil {
+		t.Fatal("unexpected data after the responses")
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("RespondStream: %s", err)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return responses, closed, details
+}
+
+func writeHandler(body string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, body+r.URL.Path)
+	})
+}
+
+func TestHttp1KeepAlive(t *testing.T) {
+	responder := &HttpHandlerResponder{Handler: writeHandler("path ")}
+	raw := "GET /one HTTP/1.1\r\nHost: example.com\r\n\r\n" +
+		"POST /two HTTP/1.1\r\nHost: example.com\r\nContent-Length: 4\r\n\r\nbody" +
+		"GET /three HTTP/1.1\r\nHost: example.com\r\n\r\n"
+	responses, _, details := serveHttp1(t, responder, raw, "GET", "POST", "GET")
+	for i, want := range []string{"path /one", "path /two", "path /three"} {
+		response := responses[i]
+		if response.status != http.StatusOK || response.body != want {
+			t.Errorf("response %d: %d %q, want 200 %q", i+1, response.status, response.body, want)
+		}
+		if got := response.header.Get("Content-Length"); got != strconv.Itoa(len(want)) {
+			t.Errorf("response %d: content length %s, want %d", i+1, got, len(want))
+		}
+		if response.close {
+			t.Errorf("response %d closes the connection", i+1)
+		}
+	}
+	if len(details) != 3 {
+		t.Fatalf("recorded %d requests, want 3", len(details))
+	}
+	if body := details[1].Body; body == nil || string(body.Data) != "body" {
+		t.Errorf("recorded body %v", body)
+	}
+}
+
+func TestHttp1ResponseFraming(t *testing.T) {
+	large := strings.Repeat("x", maxBufferedResponse+1)
+	tests := []struct {
+		name             string
+		handler          http.HandlerFunc
+		request          string
+		method           string
+		status           int
+		contentLength    string
+		transferEncoding []string
+		body             string
+		closed           bool
+	}{
+		{
+			name:          "buffered",
+			handler:       func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "hello") },
+			request:       "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			status:        http.StatusOK,
+			contentLength: "5",
+			body:          "hello",
+		},
+		{
+			name:             "large body is chunked",
+			handler:          func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, large) },
+			request:          "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			status:           http.StatusOK,
+			transferEncoding: []string{"chunked"},
+			body:             large,
+		},
+		{
+			name: "flushed body is chunked",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				_, _ = io.WriteString(w, "first ")
+				w.(http.Flusher).Flush()
+				_, _ = io.WriteString(w, "second")
+			},
+			request:          "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			status:           http.StatusOK,
+			transferEncoding: []string{"chunked"},
+			body:             "first second",
+		},
+		{
+			name:    "large body of HTTP/1.0 is delimited by closing",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, large) },
+			request: "GET / HTTP/1.0\r\nHost: x\r\n\r\n",
+			status:  http.StatusOK,
+			body:    large,
+			closed:  true,
+		},
+		{
+			name: "explicit content length",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Length", "3")
+				_, _ = io.WriteString(w, "abc")
+			},
+			request:       "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			status:        http.StatusOK,
+			contentLength: "3",
+			body:          "abc",
+		},
+		{
+			name:    "head has no body",
+			handler: func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "hidden") },
+			request: "HEAD / HTTP/1.1\r\nHost: x\r\n\r\n",
+			method:  http.MethodHead,
+			status:  http.StatusOK,
+		},
+		{
+			name: "no content",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusNoContent)
+				_, _ = io.WriteString(w, "ignored")
+			},
+			request: "GET / HTTP/1.1\r\nHost: x\r\n\r\n",
+			status:  http.StatusNoContent,
+		},
+		{
+			name:          "connection close",
+			handler:       func(w http.ResponseWriter, r *http.Request) { _, _ = io.WriteString(w, "bye") },
+			request:       "GET / HTTP/1.1\r\nHost: x\r\nConnection: close\r\n\r\n",
+			status:        http.StatusOK,
+			contentLength: "3",
+			body:          "bye",
+			closed:        true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			responses, closed, details := serveHttp1(t, &HttpHandlerResponder{Handler: test.handler}, test.request, method)
+			response := responses[0]
+			if response.status != test.status {
+				t.Errorf("status %d, want %d", response.status, test.status)
+			}
+			if got := response.header.Get("Content-Length"); got != test.contentLength {
+				t.Errorf("content length %q, want %q", got, test.contentLength)
+			}
+			if strings.Join(response.transferEncoding, ",") != strings.Join(test.transferEncoding, ",") {
+				t.Errorf("transfer encoding %v, want %v", response.transferEncoding, test.transferEncoding)
+			}
+			if response.body != test.body {
+				t.Errorf("body of %d bytes, want %d bytes", len(response.body), len(test.body))
+			}
+			if test.closed && (!closed || !response.close) {
+				t.Errorf("connection not closed")
+			}
+			if len(details) != 1 || details[0].Response.StatusCode != test.status {
+				t.Fatalf("recorded %v", details)
+			}
+		})
+	}
+}
+
+func TestHttp1MaxRequests(t *testing.T) {
+	responder := &HttpHandlerResponder{Handler: writeHandler(""), MaxRequests: 2}
+	raw := strings.Repeat("GET /x HTTP/1.1\r\nHost: x\r\n\r\n", 3)
+	responses, closed, details := serveHttp1(t, responder, raw, "GET", "GET")
+	if responses[0].close || !responses[1].close {
+		t.Errorf("closing announced by the responses: %v, %v", responses[0].close, responses[1].close)
+	}
+	if !closed || len(details) != 2 {
+		t.Errorf("closed %v after %d requests", closed, len(details))
+	}
+}
+
+func TestHttp1SlowHeaders(t *testing.T) {
+	responder := &HttpHandlerResponder{Handler: writeHandler(""), IdleTimeout: 100 * time.Millisecond}
+	server, client := net.Pipe()
+	defer client.Close()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = responder.RespondStream(server, func(RequestDetails, time.Time) {})
+		server.Close()
+	}()
+	if _, err := io.WriteString(client, "GET / HTTP/1.1\r\nHo"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("incomplete headers keep the connection open")
+	}
+}
+
+func TestHttp1HeaderLimit(t *testing.T) {
+	header := func(size int) string {
+		return "GET / HTTP/1.1\r\nHost: x\r\nX-A: " + strings.Repeat("a", size) + "\r\n\r\n"
+	}
+	tests := []struct {
+		name     string
+		requests []string
+		statuses []int
+	}{
+		// the limit is reset for every request on the connection
+		{name: "below the limit", requests: []string{header(3000), header(3000)}, statuses: []int{200, 200}},
+		{name: "above the limit", requests: []string{header(3000), header(64 * 1024)}, statuses: []int{200, http.StatusRequestHeaderFieldsTooLarge}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := &HttpHandlerResponder{Handler: writeHandler(""), MaxHeaderBytes: 1024}
+			server, client := net.Pipe()
+			defer client.Close()
+			var count int
+			done := make(chan error, 1)
+			go func() {
+				done <- responder.RespondStream(server, func(RequestDetails, time.Time) { count++ })
+				server.Close()
+			}()
+			go func() {
+				// the server stops reading at the limit
+				_, _ = io.WriteString(client, strings.Join(test.requests, ""))
+			}()
+			reader := bufio.NewReader(client)
+			for i, status := range test.statuses {
+				response, err := http.ReadResponse(reader, nil)
+				if err != nil {
+					t.Fatalf("response %d: %s", i+1, err)
+				}
+				_, _ = io.Copy(ioutil.Discard, response.Body)
+				if response.StatusCode != status {
+					t.Errorf("response %d has status %d, want %d", i+1, response.StatusCode, status)
+				}
+			}
+			client.Close()
+			err := <-done
+			rejected := test.statuses[len(test.statuses)-1] == http.StatusRequestHeaderFieldsTooLarge
+			if rejected && (err == nil || !strings.Contains(err.Error(), "exceed")) {
+				t.Errorf("RespondStream returned %v", err)
+			}
+			if recorded := len(test.statuses); rejected && count != recorded-1 || !rejected && count != recorded {
+				t.Errorf("%d requests recorded", count)
+			}
+		})
+	}
+}
+
+func TestHttp1ExpectContinue(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})
+	tests := []struct {
+		name     string
+		handler  http.Handler
+		body     string
+		response string
+	}{
+		{name: "read by the handler", handler: echo, body: "<xml/>", response: "<xml/>"},
+		// the body is still captured while it is drained
+		{name: "drained", handler: writeHandler("ignored "), body: "<xml/>", response: "ignored /"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			responder := &HttpHandlerResponder{Handler: test.handler, IdleTimeout: 200 * time.Millisecond}
+			server, client := net.Pipe()
+			defer client.Close()
+			recorded := make(chan *HttpRequestDetails, 1)
+			go func() {
+				_ = responder.RespondStream(server, func(d RequestDetails, _ time.Time) {
+					recorded <- d.(*HttpRequestDetails)
+				})
+				server.Close()
+			}()
+			if err := client.SetDeadline(time.Now().Add(time.Second)); err != nil {
+				t.Fatal(err)
+			}
+			request := "POST / HTTP/1.1\r\nHost: x\r\nExpect: 100-continue\r\nContent-Length: " +
+				strconv.Itoa(len(test.body)) + "\r\n\r\n"
+			if _, err := io.WriteString(client, request); err != nil {
+				t.Fatal(err)
+			}
+			reader := bufio.NewReader(client)
+			response, err := http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("interim response: %s", err)
+			}
+			if response.StatusCode != http.StatusContinue {
+				t.Fatalf("status %d before the body was sent", response.StatusCode)
+			}
+			if _, err := io.WriteString(client, test.body); err != nil {
+				t.Fatal(err)
+			}
+			response, err = http.ReadResponse(reader, nil)
+			if err != nil {
+				t.Fatalf("response: %s", err)
+			}
+			body, _ := ioutil.ReadAll(response.Body)
+			if response.StatusCode != http.StatusOK || string(body) != test.response {
+				t.Errorf("response %d %q", response.StatusCode, body)
+			}
+			details := <-recorded
+			if string(details.Body.Data) != test.body || details.Body.Size != int64(len(test.body)) {
+				t.Errorf("captured body %q of %d bytes", details.Body.Data, details.Body.Size)
+			}
+		})
+	}
+}
@@ -0,0 +1,471 @@
+package ohren
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	metadataRole           = "app-instance-role"
+	metadataRegion         = "us-east-1"
+	metadataZone           = "us-east-1a"
+	metadataProject        = "app-production"
+	metadataProjectNumber  = "482910377115"
+	metadataLocation       = "eastus"
+	metadataCredentialsTTL = 6 * time.Hour
+	// maxMetadataClients limits the amount of clients with a generated token
+	maxMetadataClients = 1000
+)
+
+// CloudMetadata emulates the metadata services of AWS (IMDSv1 and IMDSv2), GCP
+// and Azure. The returned credentials and identifiers contain the correlation
+// token of the request. If the request contains none, a token is generated for
+// the client and the owner of the requested namespace and reused until the
+// credentials expire. Issuers and scripts refer to the payload hostname of the
+// token.
+type CloudMetadata struct {
+	// Tokens are used to find and generate correlation tokens. May be nil.
+	Tokens *TokenRegistry
+
+	mutex   sync.Mutex
+	clients map[string]*metadataClient
+}
+
+// metadataClient is the token generated for a client.
+type metadataClient struct {
+	token     string
+	generated time.Time
+}
+
+// metadataInstance are the fake values of a response.
+type metadataInstance struct {
+	Token     string
+	TokenHost string
+	Now       time.Time
+}
+
+func (m *CloudMetadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	instance, err := m.instance(r)
+	if err != nil {
+		AddHttpNote(w, "metadata token failed: "+err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	AddHttpNote(w, "metadata token: "+instance.Token)
+	WrapHttpDetails(w, func(details *HttpRequestDetails) RequestDetails {
+		details.IssuedTokens = append(details.IssuedTokens, instance.Token)
+		return details
+	})
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/latest/"):
+		instance.serveAws(w, r)
+	case strings.HasPrefix(r.URL.Path, "/computeMetadata/"):
+		instance.serveGcp(w, r)
+	case strings.HasPrefix(r.URL.Path, "/metadata/"):
+		instance.serveAzure(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (m *CloudMetadata) instance(r *http.Request) (*metadataInstance, error) {
+	data := NewHttpTemplateData(r, m.Tokens)
+	instance := &metadataInstance{
+		Token:     data.Token,
+		TokenHost: data.TokenHost,
+		Now:       data.Time.UTC().Truncate(time.Second),
+	}
+	if instance.Token != "" {
+		return instance, nil
+	}
+	owner := ""
+	if m.Tokens != nil {
+		owner = m.Tokens.HostOwner(NormalizeHost(r.Host))
+	}
+	var err error
+	instance.Token, err = m.clientToken(r.RemoteAddr, owner)
+	if err != nil {
+		return nil, err
+	}
+	instance.TokenHost = instance.Token
+	if m.Tokens != nil {
+		instance.TokenHost = m.Tokens.Host(instance.Token)
+	}
+	return instance, nil
+}
+
+// clientToken returns the token of the client, a new one is generated if the
+// client has none or its credentials expired.
+func (m *CloudMetadata) clientToken(remoteAddress string, owner string) (string, error) {
+	address, _, err := net.SplitHostPort(remoteAddress)
+	if err != nil {
+		address = remoteAddress
+	}
+	key := owner + "/" + address
+	now := time.Now()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if client, ok := m.clients[key]; ok && now.Sub(client.generated) < metadataCredentialsTTL {
+		return client.token, nil
+	}
+	var token string
+	if m.Tokens != nil {
+		token, err = m.Tokens.Generate(owner)
+	} else {
+		token, err = randomToken()
+	}
+	if err != nil {
+		return "", err
+	}
+	if m.clients == nil {
+		m.clients = make(map[string]*metadataClient)
+	}
+	if client, ok := m.clients[key]; ok {
+		m.forget(key, client)
+	} else if len(m.clients) >= maxMetadataClients {
+		m.removeOldest(now)
+	}
+	m.clients[key] = &metadataClient{
+		token:     token,
+		generated: now,
+	}
+	return token, nil
+}
+
+// removeOldest removes the clients with expired credentials or the oldest
+// client if none expired.
+func (m *CloudMetadata) removeOldest(now time.Time) {
+	oldestKey := ""
+	var oldest *metadataClient
+	for key, client := range m.clients {
+		if now.Sub(client.generated) >= metadataCredentialsTTL {
+			m.forget(key, client)
+		} else if oldest == nil || client.generated.Before(oldest.generated) {
+			oldestKey, oldest = key, client
+		}
+	}
+	if len(m.clients) >= maxMetadataClients && oldest != nil {
+		m.forget(oldestKey, oldest)
+	}
+}
+
+// forget removes the client and its token, requests using it are no longer tagged.
+func (m *CloudMetadata) forget(key string, client *metadataClient) {
+	delete(m.clients, key)
+	if m.Tokens != nil {
+		if err := m.Tokens.Remove(client.token); err != nil {
+			log.Printf("failed to remove metadata token: %s", err)
+		}
+	}
+}
+
+// fakeBytes returns n bytes derived from the token and the label.
+func (i *metadataInstance) fakeBytes(label string, n int) []byte {
+	var data []byte
+	for counter := 0; len(data) < n; counter++ {
+		sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d:%s", label, counter, i.Token)))
+		data = append(data, sum[:]...)
+	}
+	return data[:n]
+}
+
+// fakeHex returns n hex characters derived from the token and the label.
+func (i *metadataInstance) fakeHex(label string, n int) string {
+	return hex.EncodeToString(i.fakeBytes(label, n))[:n]
+}
+
+// fakeBase64 returns n base64 characters derived from the token and the label.
+func (i *metadataInstance) fakeBase64(label string, n int) string {
+	return base64.StdEncoding.EncodeToString(i.fakeBytes(label, n))[:n]
+}
+
+func (i *metadataInstance) accountID() string {
+	digits := make([]byte, 12)
+	for n, c := range i.fakeHex("account", 12) {
+		digits[n] = '0' + byte(c)%10
+	}
+	return string(digits)
+}
+
+func (i *metadataInstance) instanceID() string {
+	return "i-0" + i.fakeHex("instance", 16)
+}
+
+func (i *metadataInstance) userData() string {
+	return fmt.Sprintf("#!/bin/bash\ncurl -fsSL http://%s/bootstrap.sh | bash\n", i.TokenHost)
+}
+
+// serveAws emulates the EC2 instance metadata service. IMDSv2 session tokens
+// are issued but not required.
+func (i *metadataInstance) serveAws(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/latest/api/token" {
+		i.serveAwsToken(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT")
+		writeMetadataText(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if r.Header.Get("X-aws-ec2-metadata-token") != "" {
+		AddHttpNote(w, "metadata: aws imdsv2")
+	} else {
+		AddHttpNote(w, "metadata: aws imdsv1")
+	}
+	credentialsPath := "/latest/meta-data/iam/security-credentials/"
+	switch r.URL.Path {
+	case "/latest/", "/latest/meta-data":
+		writeMetadataText(w, http.StatusOK, "dynamic\nmeta-data\nuser-data")
+	case "/latest/meta-data/":
+		writeMetadataText(w, http.StatusOK, strings.Join([]string{
+			"ami-id", "hostname", "iam/", "instance-id", "instance-type", "local-hostname",
+			"local-ipv4", "placement/", "public-hostname", "public-ipv4", "security-groups",
+		}, "\n"))
+	case "/latest/meta-data/ami-id":
+		writeMetadataText(w, http.StatusOK, "ami-0"+i.fakeHex("ami", 16))
+	case "/latest/meta-data/instance-id":
+		writeMetadataText(w, http.StatusOK, i.instanceID())
+	case "/latest/meta-data/instance-type":
+		writeMetadataText(w, http.StatusOK, "t3.medium")
+	case "/latest/meta-data/hostname", "/latest/meta-data/local-hostname":
+		writeMetadataText(w, http.StatusOK, "ip-10-0-12-34."+metadataRegion+".compute.internal")
+	case "/latest/meta-data/public-hostname":
+		writeMetadataText(w, http.StatusOK, "ec2-"+i.TokenHost)
+	case "/latest/meta-data/local-ipv4":
+		writeMetadataText(w, http.StatusOK, "10.0.12.34")
+	case "/latest/meta-data/public-ipv4":
+		writeMetadataText(w, http.StatusOK, "54.210.17.82")
+	case "/latest/meta-data/security-groups":
+		writeMetadataText(w, http.StatusOK, "app-production")
+	case "/latest/meta-data/placement/":
+		writeMetadataText(w, http.StatusOK, "availability-zone\nregion")
+	case "/latest/meta-data/placement/availability-zone":
+		writeMetadataText(w, http.StatusOK, metadataZone)
+	case "/latest/meta-data/placement/region":
+		writeMetadataText(w, http.StatusOK, metadataRegion)
+	case "/latest/meta-data/iam/":
+		writeMetadataText(w, http.StatusOK, "info\nsecurity-credentials/")
+	case "/latest/meta-data/iam/info":
+		writeMetadataJSON(w, http.StatusOK, map[string]string{
+			"Code":               "Success",
+			"LastUpdated":        i.Now.Format(time.RFC3339),
+			"InstanceProfileArn": fmt.Sprintf("arn:aws:iam::%s:instance-profile/%s", i.accountID(), metadataRole),
+			"InstanceProfileId":  "AIPA" + strings.ToUpper(i.Token),
+		})
+	case credentialsPath:
+		writeMetadataText(w, http.StatusOK, metadataRole)
+	case credentialsPath + metadataRole:
+		AddHttpNote(w, "metadata: aws credentials")
+		writeMetadataJSON(w, http.StatusOK, map[string]string{
+			"Code":            "Success",
+			"LastUpdated":     i.Now.Format(time.RFC3339),
+			"Type":            "AWS-HMAC",
+			"AccessKeyId":     "ASIA" + strings.ToUpper(i.Token),
+			"SecretAccessKey": i.fakeBase64("secret", 40),
+			"Token":           "IQoJb3JpZ2luX2VjE" + i.Token + i.fakeBase64("session", 300),
+			"Expiration":      i.Now.Add(metadataCredentialsTTL).Format(time.RFC3339),
+		})
+	case "/latest/dynamic/instance-identity/document":
+		writeMetadataJSON(w, http.StatusOK, map[string]interface{}{
+			"accountId":        i.accountID(),
+			"architecture":     "x86_64",
+			"availabilityZone": metadataZone,
+			"imageId":          "ami-0" + i.fakeHex("ami", 16),
+			"instanceId":       i.instanceID(),
+			"instanceType":     "t3.medium",
+			"pendingTime":      i.Now.Add(-72 * time.Hour).Format(time.RFC3339),
+			"privateIp":        "10.0.12.34",
+			"region":           metadataRegion,
+			"version":          "2017-09-30",
+		})
+	case "/latest/user-data":
+		writeMetadataText(w, http.StatusOK, i.userData())
+	default:
+		writeMetadataText(w, http.StatusNotFound, "")
+	}
+}
+
+// serveAwsToken issues IMDSv2 session tokens. Like the real service it rejects
+// forwarded requests and requests without a TTL.
+func (i *metadataInstance) serveAwsToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		w.Header().Set("Allow", "OPTIONS, PUT")
+		writeMetadataText(w, http.StatusMethodNotAllowed, "")
+		return
+	}
+	if r.Header.Get("X-Forwarded-For") != "" {
+		writeMetadataText(w, http.StatusForbidden, "")
+		return
+	}
+	ttl, err := strconv.Atoi(r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds"))
+	if err != nil || ttl < 1 || ttl > 21600 {
+		writeMetadataText(w, http.StatusBadRequest, "")
+		return
+	}
+	AddHttpNote(w, "metadata: aws imdsv2 token")
+	w.Header().Set("X-aws-ec2-metadata-token-ttl-seconds", strconv.Itoa(ttl))
+	writeMetadataText(w, http.StatusOK, "AQAEA"+i.Token+i.fakeBase64("imdsv2", 35))
+}
+
+// serveGcp emulates the compute engine metadata server.
+func (i *metadataInstance) serveGcp(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Metadata-Flavor", "Google")
+	if r.Header.Get("Metadata-Flavor") != "Google" {
+		AddHttpNote(w, "metadata: gcp without Metadata-Flavor")
+		writeMetadataText(w, http.StatusForbidden, "Missing required header \"Metadata-Flavor\": \"Google\"")
+		return
+	}
+	AddHttpNote(w, "metadata: gcp")
+	serviceAccount := metadataProjectNumber + "-compute@developer.gserviceaccount.com"
+	accountPath := "/computeMetadata/v1/instance/service-accounts/default/"
+	switch r.URL.Path {
+	case "/computeMetadata/v1/":
+		writeMetadataText(w, http.StatusOK, "instance/\nproject/")
+	case "/computeMetadata/v1/project/project-id":
+		writeMetadataText(w, http.StatusOK, metadataProject)
+	case "/computeMetadata/v1/project/numeric-project-id":
+		writeMetadataText(w, http.StatusOK, metadataProjectNumber)
+	case "/computeMetadata/v1/instance/hostname":
+		writeMetadataText(w, http.StatusOK, "app-1."+metadataZone+".c."+metadataProject+".internal")
+	case "/computeMetadata/v1/instance/id":
+		writeMetadataText(w, http.StatusOK, i.accountID()+i.fakeHex("id", 6))
+	case "/computeMetadata/v1/instance/zone":
+		writeMetadataText(w, http.StatusOK, "projects/"+metadataProjectNumber+"/zones/"+metadataZone)
+	case "/computeMetadata/v1/instance/attributes/startup-script":
+		writeMetadataText(w, http.StatusOK, i.userData())
+	case "/computeMetadata/v1/instance/service-accounts/":
+		writeMetadataText(w, http.StatusOK, "default/\n"+serviceAccount+"/")
+	case accountPath:
+		writeMetadataText(w, http.StatusOK, "aliases\nemail\nidentity\nscopes\ntoken")
+	case accountPath + "email":
+		writeMetadataText(w, http.StatusOK, serviceAccount)
+	case accountPath + "scopes":
+		writeMetadataText(w, http.StatusOK, "https://www.googleapis.com/auth/cloud-platform")
+	case accountPath + "token":
+		AddHttpNote(w, "metadata: gcp credentials")
+		writeMetadataJSON(w, http.StatusOK, map[string]interface{}{
+			"access_token": "ya29.c." + i.Token + i.fakeBase64("gcp", 120),
+			"expires_in":   3599,
+			"token_type":   "Bearer",
+		})
+	case accountPath + "identity":
+		AddHttpNote(w, "metadata: gcp identity")
+		writeMetadataText(w, http.StatusOK, i.jwt(map[string]interface{}{
+			"iss":   "https://" + i.TokenHost,
+			"aud":   r.URL.Query().Get("audience"),
+			"email": serviceAccount,
+			"sub":   i.accountID(),
+		}))
+	default:
+		writeMetadataText(w, http.StatusNotFound, "")
+	}
+}
+
+// serveAzure emulates the Azure instance metadata service.
+func (i *metadataInstance) serveAzure(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Metadata") != "true" {
+		AddHttpNote(w, "metadata: azure without Metadata header")
+		writeMetadataJSON(w, http.StatusBadRequest, map[string]string{
+			"error": "Bad request. Required metadata header not specified",
+		})
+		return
+	}
+	AddHttpNote(w, "metadata: azure")
+	subscription := i.uuid("subscription")
+	switch r.URL.Path {
+	case "/metadata/instance", "/metadata/instance/compute":
+		compute := map[string]interface{}{
+			"azEnvironment":     "AzurePublicCloud",
+			"location":          metadataLocation,
+			"name":              "app-vm-1",
+			"osType":            "Linux",
+			"resourceGroupName": "app-production",
+			"subscriptionId":    subscription,
+			"vmId":              i.uuid("vm"),
+			"vmSize":            "Standard_D2s_v3",
+			"customData":        base64.StdEncoding.EncodeToString([]byte(i.userData())),
+		}
+		if r.URL.Path == "/metadata/instance/compute" {
+			writeMetadataJSON(w, http.StatusOK, compute)
+			return
+		}
+		writeMetadataJSON(w, http.StatusOK, map[string]interface{}{
+			"compute": compute,
+			"network": map[string]interface{}{
+				"interface": []interface{}{},
+			},
+		})
+	case "/metadata/identity/oauth2/token":
+		resource := r.URL.Query().Get("resource")
+		if resource == "" {
+			writeMetadataJSON(w, http.StatusBadRequest, map[string]string{
+				"error":             "invalid_request",
+				"error_description": "Required query variable 'resource' is missing",
+			})
+			return
+		}
+		AddHttpNote(w, "metadata: azure credentials")
+		expires := i.Now.Add(metadataCredentialsTTL)
+		clientID := i.uuid("client")
+		writeMetadataJSON(w, http.StatusOK, map[string]string{
+			"access_token": i.jwt(map[string]interface{}{
+				"iss":   "https://" + i.TokenHost + "/" + i.uuid("tenant") + "/",
+				"aud":   resource,
+				"appid": clientID,
+				"oid":   i.uuid("object"),
+				"tid":   i.uuid("tenant"),
+				"iat":   i.Now.Unix(),
+				"exp":   expires.Unix(),
+			}),
+			"client_id":  clientID,
+			"expires_in": strconv.Itoa(int(metadataCredentialsTTL.Seconds())),
+			"expires_on": strconv.FormatInt(expires.Unix(), 10),
+			"not_before": strconv.FormatInt(i.Now.Unix(), 10),
+			"resource":   resource,
+			"token_type": "Bearer",
+		})
+	default:
+		writeMetadataJSON(w, http.StatusNotFound, map[string]string{
+			"error": "Not found",
+		})
+	}
+}
+
+func (i *metadataInstance) uuid(label string) string {
+	id := i.fakeHex(label, 32)
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// jwt returns a token with the claims, a fake signature and the correlation token as key ID.
+func (i *metadataInstance) jwt(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"kid": i.Token,
+		"typ": "JWT",
+	})
+	payload, _ := json.Marshal(claims)
+	encoding := base64.RawURLEncoding
+	return encoding.EncodeToString(header) + "." + encoding.EncodeToString(payload) + "." + encoding.EncodeToString(i.fakeBytes("signature", 256))
+}
+
+func writeMetadataText(w http.ResponseWriter, statusCode int, body string) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write([]byte(body))
+}
+
+func writeMetadataJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(value)
+}
@@ -0,0 +1,164 @@
+package ohren
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCloudMetadata(t *testing.T) {
+	tokens := NewTokenRegistry("d.example")
+	token, err := tokens.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		header http.Header
+		status int
+		// token is set if the body contains the correlation token
+		token bool
+	}{
+		{name: "aws index", path: "/latest/meta-data/", status: http.StatusOK},
+		{name: "aws role", path: "/latest/meta-data/iam/security-credentials/", status: http.StatusOK},
+		{name: "aws credentials", path: "/latest/meta-data/iam/security-credentials/app-instance-role", status: http.StatusOK, token: true},
+		{
+			name:   "aws credentials with session",
+			path:   "/latest/meta-data/iam/security-credentials/app-instance-role",
+			header: http.Header{"X-Aws-Ec2-Metadata-Token": {"AQAEA"}},
+			status: http.StatusOK,
+			token:  true,
+		},
+		{name: "aws user data", path: "/latest/user-data", status: http.StatusOK, token: true},
+		{name: "aws unknown", path: "/latest/meta-data/unknown", status: http.StatusNotFound},
+		{name: "aws post", method: http.MethodPost, path: "/latest/meta-data/", status: http.StatusMethodNotAllowed},
+		{
+			name:   "aws session",
+			method: http.MethodPut,
+			path:   "/latest/api/token",
+			header: http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"21600"}},
+			status: http.StatusOK,
+			token:  true,
+		},
+		{name: "aws session without ttl", method: http.MethodPut, path: "/latest/api/token", status: http.StatusBadRequest},
+		{
+			name:   "aws forwarded session",
+			method: http.MethodPut,
+			path:   "/latest/api/token",
+			header: http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"60"}, "X-Forwarded-For": {"192.0.2.2"}},
+			status: http.StatusForbidden,
+		},
+		{name: "aws session get", path: "/latest/api/token", status: http.StatusMethodNotAllowed},
+		{
+			name:   "gcp token",
+			path:   "/computeMetadata/v1/instance/service-accounts/default/token",
+			header: http.Header{"Metadata-Flavor": {"Google"}},
+			status: http.StatusOK,
+			token:  true,
+		},
+		{name: "gcp without flavor", path: "/computeMetadata/v1/instance/service-accounts/default/token", status: http.StatusForbidden},
+		// the token is contained in the encoded JWT, see TestCloudMetadataAzureToken
+		{
+			name:   "azure token",
+			path:   "/metadata/identity/oauth2/token?resource=https%3A%2F%2Fmanagement.azure.com%2F",
+			header: http.Header{"Metadata": {"true"}},
+			status: http.StatusOK,
+		},
+		{name: "azure token without resource", path: "/metadata/identity/oauth2/token", header: http.Header{"Metadata": {"true"}}, status: http.StatusBadRequest},
+		{name: "azure without header", path: "/metadata/instance", status: http.StatusBadRequest},
+		{name: "other", path: "/other", status: http.StatusNotFound},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metadata := &CloudMetadata{Tokens: tokens}
+			r := httptest.NewRequest(test.method, "http://"+token+".d.example"+test.path, nil)
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			w := httptest.NewRecorder()
+			metadata.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d: %s", w.Code, test.status, w.Body.String())
+			}
+			body := strings.ToLower(w.Body.String())
+			if containsToken := strings.Contains(body, token); containsToken != test.token {
+				t.Errorf("body contains token = %v: %s", containsToken, body)
+			}
+		})
+	}
+}
+
+func TestCloudMetadataAzureToken(t *testing.T) {
+	tokens := NewTokenRegistry("d.example")
+	token, err := tokens.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata := &CloudMetadata{Tokens: tokens}
+	r := httptest.NewRequest(http.MethodGet, "http://"+token+".d.example/metadata/identity/oauth2/token?resource=https://vault.azure.net", nil)
+	r.Header.Set("Metadata", "true")
+	w := httptest.NewRecorder()
+	metadata.ServeHTTP(w, r)
+	var response map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(response["access_token"], ".")
+	if len(parts) != 3 {
+		t.Fatalf("access token %q is no JWT", response["access_token"])
+	}
+	var header, claims map[string]interface{}
+	for i, value := range []*map[string]interface{}{&header, &claims} {
+		decoded, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(decoded, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header["kid"] != token {
+		t.Errorf("key ID %v, want %s", header["kid"], token)
+	}
+	if issuer, _ := claims["iss"].(string); !strings.HasPrefix(issuer, "https://"+tokens.Host(token)+"/") {
+		t.Errorf("issuer %q", issuer)
+	}
+	if claims["aud"] != "https://vault.azure.net" || response["resource"] != "https://vault.azure.net" {
+		t.Errorf("audience %v, resource %q", claims["aud"], response["resource"])
+	}
+}
+
+func TestCloudMetadataClientToken(t *testing.T) {
+	tokens := NewTokenRegistry("d.example")
+	tokens.SetNamespace("alice", "red")
+	metadata := &CloudMetadata{Tokens: tokens}
+	accessKey := func(host string, remoteAddress string) string {
+		r := httptest.NewRequest(http.MethodGet, "http://"+host+"/latest/meta-data/iam/security-credentials/app-instance-role", nil)
+		r.RemoteAddr = remoteAddress
+		w := httptest.NewRecorder()
+		metadata.ServeHTTP(w, r)
+		var credentials map[string]string
+		if err := json.NewDecoder(w.Body).Decode(&credentials); err != nil {
+			t.Fatal(err)
+		}
+		return strings.ToLower(strings.TrimPrefix(credentials["AccessKeyId"], "ASIA"))
+	}
+	first := accessKey("red.d.example", "192.0.2.1:1000")
+	if info, ok := tokens.tokens[first]; !ok || info.owner != "alice" {
+		t.Errorf("generated token %q registered for %q", first, info.owner)
+	}
+	if second := accessKey("red.d.example", "192.0.2.1:2000"); second != first {
+		t.Errorf("token %q for the same client, want %q", second, first)
+	}
+	if other := accessKey("red.d.example", "192.0.2.2:1000"); other == first {
+		t.Error("same token for another client")
+	}
+	if other := accessKey("d.example", "192.0.2.1:1000"); other == first {
+		t.Error("same token for another owner")
+	}
+}
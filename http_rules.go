@@ -0,0 +1,202 @@
+package ohren
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// AnyToken as HttpMatcher.Token matches requests containing any registered token.
+const AnyToken = "*"
+
+// HttpResponse is a fixed response.
+type HttpResponse struct {
+	// StatusCode defaults to 200
+	StatusCode  int
+	Header      http.Header
+	ContentType string
+	Body        []byte
+}
+
+func (r *HttpResponse) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	for name, values := range r.Header {
+		for _, value := range values {
+			w.Header().Add(name, value)
+		}
+	}
+	if r.ContentType != "" {
+		w.Header().Set("Content-Type", r.ContentType)
+	}
+	statusCode := r.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+	}
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(r.Body)
+}
+
+// HttpMatcher selects requests. Empty fields match all requests.
+type HttpMatcher struct {
+	// Host is a glob pattern (e.g. "*.example.com") matched against the host without port
+	Host string
+	// Path is a glob pattern (e.g. "/static/*.js") matched against the path
+	Path string
+	// PathRegex is matched against the path
+	PathRegex *regexp.Regexp
+	// Methods contains the accepted methods
+	Methods []string
+	// Headers maps header names to expressions of which at least one value must match
+	Headers map[string]*regexp.Regexp
+	// Token must be contained in the host, the URI or a header value.
+	// AnyToken matches any registered correlation token.
+	Token string
+}
+
+// Matches returns true if the request matches all criteria. The tokens are used
+// to match AnyToken and may be nil.
+func (m *HttpMatcher) Matches(r *http.Request, tokens *TokenRegistry) bool {
+	if m.Host != "" {
+		if matched, _ := path.Match(NormalizeHost(m.Host), NormalizeHost(r.Host)); !matched {
+			return false
+		}
+	}
+	if m.Path != "" {
+		if matched, _ := path.Match(m.Path, r.URL.Path); !matched {
+			return false
+		}
+	}
+	if m.PathRegex != nil && !m.PathRegex.MatchString(r.URL.Path) {
+		return false
+	}
+	if len(m.Methods) > 0 && !m.matchesMethod(r.Method) {
+		return false
+	}
+	for name, expression := range m.Headers {
+		if !matchesAny(expression, r.Header.Values(name)) {
+			return false
+		}
+	}
+	if m.Token != "" && !m.matchesToken(r, tokens) {
+		return false
+	}
+	return true
+}
+
+func (m *HttpMatcher) matchesMethod(method string) bool {
+	for _, accepted := range m.Methods {
+		if strings.EqualFold(accepted, method) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *HttpMatcher) matchesToken(r *http.Request, tokens *TokenRegistry) bool {
+	content := searchableRequestContent(r)
+	if m.Token == AnyToken {
+		return tokens != nil && len(tokens.Find(content...)) > 0
+	}
+	token := strings.ToLower(m.Token)
+	for _, c := range content {
+		if strings.Contains(strings.ToLower(c), token) {
+			return true
+		}
+	}
+	return false
+}
+
+// searchableRequestContent returns the parts of the request which are searched
+// for correlation tokens before the body is read.
+func searchableRequestContent(r *http.Request) []string {
+	content := []string{r.Host, r.RequestURI}
+	for _, values := range r.Header {
+		content = append(content, values...)
+	}
+	return content
+}
+
+func matchesAny(expression *regexp.Regexp, values []string) bool {
+	for _, value := range values {
+		if expression.MatchString(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// HttpRule responds with the handler to matching requests.
+type HttpRule struct {
+	// Name identifies the rule in the notes of the recorded request
+	Name    string
+	Match   HttpMatcher
+	Handler http.Handler
+}
+
+// HttpRules responds with the handler of the first matching rule or with the
+// default handler if no rule matches.
+type HttpRules struct {
+	Rules   []*HttpRule
+	Default http.Handler
+	// Tokens are used to match rules requiring any token
+	Tokens *TokenRegistry
+}
+
+func (h *HttpRules) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for _, rule := range h.Rules {
+		if rule.Match.Matches(r, h.Tokens) {
+			AddHttpNote(w, "rule: "+rule.Name)
+			rule.Handler.ServeHTTP(w, r)
+			return
+		}
+	}
+	if h.Default == nil {
+		http.NotFound(w, r)
+		return
+	}
+	h.Default.ServeHTTP(w, r)
+}
+
+// HttpRedirect redirects to a fixed target or to the target in a query parameter.
+// The target is not validated, so any scheme (e.g. gopher://, file://) and internal
+// addresses can be used.
+type HttpRedirect struct {
+	// StatusCode defaults to 302
+	StatusCode int
+	Location   string
+	// QueryParameter contains the target if it is present in the request.
+	// Location is used otherwise.
+	QueryParameter string
+}
+
+func (r *HttpRedirect) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	location := r.Location
+	if r.QueryParameter != "" {
+		if target := request.URL.Query().Get(r.QueryParameter); target != "" {
+			location = target
+		}
+	}
+	if location == "" {
+		AddHttpNote(w, "redirect: no target")
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+	statusCode := r.StatusCode
+	if statusCode == 0 {
+		statusCode = http.StatusFound
+	}
+	AddHttpNote(w, fmt.Sprintf("redirect: %d %s", statusCode, location))
+	w.Header().Set("Location", location)
+	w.WriteHeader(statusCode)
+}
+
+// IsRedirectStatus returns true for the status codes supported by HttpRedirect.
+func IsRedirectStatus(statusCode int) bool {
+	switch statusCode {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,188 @@
+package ohren
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func TestHttpMatcher(t *testing.T) {
+	tokens := NewTokenRegistry("d.example")
+	token, err := tokens.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		matcher HttpMatcher
+		method  string
+		target  string
+		header  http.Header
+		matches bool
+	}{
+		{name: "empty", target: "http://d.example/", matches: true},
+		{name: "host", matcher: HttpMatcher{Host: "*.D.example"}, target: "http://abc.d.example:8080/", matches: true},
+		{name: "other host", matcher: HttpMatcher{Host: "*.d.example"}, target: "http://d.example/", matches: false},
+		{name: "path", matcher: HttpMatcher{Path: "/js/*.js"}, target: "http://d.example/js/a.js?x=1", matches: true},
+		{name: "path glob within segment", matcher: HttpMatcher{Path: "/js/*.js"}, target: "http://d.example/js/a/b.js", matches: false},
+		{name: "path regex", matcher: HttpMatcher{PathRegex: regexp.MustCompile(`^/js/.*\.js$`)}, target: "http://d.example/js/a/b.js", matches: true},
+		{name: "other path regex", matcher: HttpMatcher{PathRegex: regexp.MustCompile(`^/js/`)}, target: "http://d.example/css/a.css", matches: false},
+		{name: "method", matcher: HttpMatcher{Methods: []string{"get", "POST"}}, method: http.MethodGet, target: "http://d.example/", matches: true},
+		{name: "other method", matcher: HttpMatcher{Methods: []string{"POST"}}, method: http.MethodPut, target: "http://d.example/", matches: false},
+		{
+			name:    "header",
+			matcher: HttpMatcher{Headers: map[string]*regexp.Regexp{"User-Agent": regexp.MustCompile("^curl/")}},
+			target:  "http://d.example/",
+			header:  http.Header{"User-Agent": {"Mozilla/5.0", "curl/8.0"}},
+			matches: true,
+		},
+		{
+			name:    "missing header",
+			matcher: HttpMatcher{Headers: map[string]*regexp.Regexp{"X-Forwarded-For": regexp.MustCompile(".*")}},
+			target:  "http://d.example/",
+			matches: false,
+		},
+		{name: "token in path", matcher: HttpMatcher{Token: "ABC"}, target: "http://d.example/x/abc", matches: true},
+		{name: "token in header", matcher: HttpMatcher{Token: "abc"}, target: "http://d.example/", header: http.Header{"Referer": {"http://abc.d.example/"}}, matches: true},
+		{name: "missing token", matcher: HttpMatcher{Token: "abc"}, target: "http://d.example/x", matches: false},
+		{name: "any token", matcher: HttpMatcher{Token: AnyToken}, target: "http://" + token + ".d.example/", matches: true},
+		{name: "any unregistered token", matcher: HttpMatcher{Token: AnyToken}, target: "http://abc.d.example/", matches: false},
+		{
+			name:    "all criteria",
+			matcher: HttpMatcher{Host: "d.example", Path: "/a", Methods: []string{"GET"}},
+			method:  http.MethodGet,
+			target:  "http://d.example/b",
+			matches: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, test.target, nil)
+			for name, values := range test.header {
+				r.Header[name] = values
+			}
+			if matches := test.matcher.Matches(r, tokens); matches != test.matches {
+				t.Errorf("matches = %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestHttpMatcherAnyTokenWithoutRegistry(t *testing.T) {
+	matcher := HttpMatcher{Token: AnyToken}
+	if matcher.Matches(httptest.NewRequest(http.MethodGet, "http://abc.d.example/", nil), nil) {
+		t.Error("matches without token registry")
+	}
+}
+
+func TestHttpRules(t *testing.T) {
+	rules := &HttpRules{
+		Rules: []*HttpRule{
+			{Name: "js", Match: HttpMatcher{Path: "/*.js"}, Handler: writeHandler("js ")},
+			{Name: "host", Match: HttpMatcher{Host: "*.d.example"}, Handler: writeHandler("host ")},
+			{Name: "shadowed", Match: HttpMatcher{Path: "/a.js"}, Handler: writeHandler("shadowed ")},
+		},
+	}
+	tests := []struct {
+		name   string
+		rules  *HttpRules
+		target string
+		status int
+		body   string
+	}{
+		{name: "first rule", rules: rules, target: "http://abc.d.example/a.js", status: http.StatusOK, body: "js /a.js"},
+		{name: "second rule", rules: rules, target: "http://abc.d.example/a.css", status: http.StatusOK, body: "host /a.css"},
+		{name: "no rule", rules: rules, target: "http://other.example/", status: http.StatusNotFound, body: "404 page not found\n"},
+		{
+			name:   "default",
+			rules:  &HttpRules{Rules: rules.Rules, Default: writeHandler("default ")},
+			target: "http://other.example/",
+			status: http.StatusOK,
+			body:   "default /",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.rules.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if w.Code != test.status || w.Body.String() != test.body {
+				t.Errorf("response %d %q, want %d %q", w.Code, w.Body.String(), test.status, test.body)
+			}
+		})
+	}
+}
+
+func TestHttpResponse(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    *HttpResponse
+		status      int
+		contentType string
+		server      string
+	}{
+		{name: "defaults", response: &HttpResponse{Body: []byte("body")}, status: http.StatusOK},
+		{
+			name: "fields",
+			response: &HttpResponse{
+				StatusCode:  http.StatusNotFound,
+				Header:      http.Header{"Server": {"nginx"}, "Content-Type": {"text/html"}},
+				ContentType: "application/json",
+				Body:        []byte("body"),
+			},
+			status:      http.StatusNotFound,
+			contentType: "application/json",
+			server:      "nginx",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.response.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+			if w.Code != test.status || w.Body.String() != "body" {
+				t.Errorf("response %d %q", w.Code, w.Body.String())
+			}
+			// the recorder sniffs the content type if it is not set
+			if contentType := w.Header().Get("Content-Type"); test.contentType != "" && contentType != test.contentType {
+				t.Errorf("content type %q, want %q", contentType, test.contentType)
+			}
+			if server := w.Header().Get("Server"); server != test.server {
+				t.Errorf("server %q, want %q", server, test.server)
+			}
+		})
+	}
+}
+
+func TestHttpRedirect(t *testing.T) {
+	tests := []struct {
+		name     string
+		redirect *HttpRedirect
+		target   string
+		status   int
+		location string
+	}{
+		{name: "location", redirect: &HttpRedirect{Location: "http://169.254.169.254/"}, target: "/r", status: http.StatusFound, location: "http://169.254.169.254/"},
+		{name: "status", redirect: &HttpRedirect{StatusCode: http.StatusTemporaryRedirect, Location: "/a"}, target: "/r", status: http.StatusTemporaryRedirect, location: "/a"},
+		{
+			name:     "query parameter",
+			redirect: &HttpRedirect{Location: "/a", QueryParameter: "to"},
+			target:   "/r?to=gopher%3A%2F%2F127.0.0.1%3A6379%2F_INFO",
+			status:   http.StatusFound,
+			location: "gopher://127.0.0.1:6379/_INFO",
+		},
+		{name: "missing query parameter", redirect: &HttpRedirect{Location: "/a", QueryParameter: "to"}, target: "/r", status: http.StatusFound, location: "/a"},
+		{name: "no target", redirect: &HttpRedirect{QueryParameter: "to"}, target: "/r", status: http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			test.redirect.ServeHTTP(w, httptest.NewRequest(http.MethodGet, test.target, nil))
+			if w.Code != test.status {
+				t.Errorf("status %d, want %d", w.Code, test.status)
+			}
+			if location := w.Header().Get("Location"); location != test.location {
+				t.Errorf("location %q, want %q", location, test.location)
+			}
+		})
+	}
+}
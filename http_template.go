@@ -0,0 +1,77 @@
+package ohren
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// HttpTemplateData is passed to the template of HttpTemplate.
+type HttpTemplateData struct {
+	Method string
+	Host   string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	// RemoteAddress is the address of the client including the port
+	RemoteAddress string
+	// Token is the first correlation token contained in the request or empty
+	Token string
+	// Tokens are all correlation tokens contained in the request
+	Tokens []string
+	// TokenHost is the payload hostname of Token or the configured hostname if
+	// the request contains no token
+	TokenHost string
+	Time      time.Time
+}
+
+// NewHttpTemplateData returns the data of the request. The tokens may be nil.
+func NewHttpTemplateData(r *http.Request, tokens *TokenRegistry) *HttpTemplateData {
+	data := &HttpTemplateData{
+		Method:        r.Method,
+		Host:          r.Host,
+		Path:          r.URL.Path,
+		Query:         r.URL.Query(),
+		Header:        r.Header,
+		RemoteAddress: r.RemoteAddr,
+		Time:          time.Now(),
+	}
+	if tokens != nil {
+		data.TokenHost = tokens.Hostname
+		data.Tokens = tokens.Find(searchableRequestContent(r)...)
+		if len(data.Tokens) > 0 {
+			data.Token = data.Tokens[0]
+			data.TokenHost = tokens.Host(data.Token)
+		}
+	}
+	return data
+}
+
+// HttpTemplate responds with the body rendered by the template with HttpTemplateData.
+type HttpTemplate struct {
+	// StatusCode defaults to 200
+	StatusCode  int
+	Header      http.Header
+	ContentType string
+	Template    *template.Template
+	// Tokens are used to find the correlation tokens in the request
+	Tokens *TokenRegistry
+}
+
+func (t *HttpTemplate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body := new(bytes.Buffer)
+	if err := t.Template.Execute(body, NewHttpTemplateData(r, t.Tokens)); err != nil {
+		AddHttpNote(w, "template failed: "+err.Error())
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	response := &HttpResponse{
+		StatusCode:  t.StatusCode,
+		Header:      t.Header,
+		ContentType: t.ContentType,
+		Body:        body.Bytes(),
+	}
+	response.ServeHTTP(w, r)
+}
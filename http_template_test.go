@@ -0,0 +1,78 @@
+package ohren
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"text/template"
+)
+
+func TestHttpTemplate(t *testing.T) {
+	tokens := NewTokenRegistry("d.example")
+	token, err := tokens.Generate("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		template string
+		tokens   *TokenRegistry
+		target   string
+		status   int
+		body     string
+	}{
+		{
+			name:     "request",
+			template: `{{.Method}} {{.Host}} {{.Path}} {{.Query.Get "id"}} {{.Header.Get "X-Test"}} {{.RemoteAddress}}`,
+			target:   "http://a.example/p?id=1",
+			status:   http.StatusOK,
+			body:     "GET a.example /p 1 test 192.0.2.1:1234",
+		},
+		{
+			name:     "token",
+			template: "{{.Token}} {{.TokenHost}} {{len .Tokens}}",
+			tokens:   tokens,
+			target:   "http://d.example/" + token,
+			status:   http.StatusOK,
+			body:     token + " " + tokens.Host(token) + " 1",
+		},
+		{
+			name:     "no token",
+			template: "{{.Token}} {{.TokenHost}} {{len .Tokens}}",
+			tokens:   tokens,
+			target:   "http://d.example/",
+			status:   http.StatusOK,
+			body:     " d.example 0",
+		},
+		{
+			name:     "without registry",
+			template: "{{.Token}}|{{.TokenHost}}",
+			target:   "http://d.example/" + token,
+			status:   http.StatusOK,
+			body:     "|",
+		},
+		{
+			name:     "failed",
+			template: "{{.Missing}}",
+			target:   "http://d.example/",
+			status:   http.StatusInternalServerError,
+			body:     "Internal Server Error\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := &HttpTemplate{
+				ContentType: "text/plain",
+				Template:    template.Must(template.New("body").Parse(test.template)),
+				Tokens:      test.tokens,
+			}
+			r := httptest.NewRequest(http.MethodGet, test.target, nil)
+			r.Header.Set("X-Test", "test")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if w.Code != test.status || w.Body.String() != test.body {
+				t.Errorf("response %d %q, want %d %q", w.Code, w.Body.String(), test.status, test.body)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package ohren
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const RequestTypeWebsocket = RequestType("WebSocket connection")
+
+const (
+	// DefaultWebsocketDuration is the default time a websocket connection is kept open
+	DefaultWebsocketDuration = 30 * time.Second
+	// maxWebsocketMessages is the maximum amount of messages read from a connection
+	maxWebsocketMessages = 1000
+	// websocketWriteWait is the time allowed to write a message to the client
+	websocketWriteWait = 5 * time.Second
+)
+
+// WebsocketMessage is a message sent or received on a websocket connection.
+type WebsocketMessage struct {
+	// Sent is true for messages sent to the client
+	Sent bool
+	Time time.Time
+	// Binary is true for binary messages and false for text messages
+	Binary bool
+	Data   []byte
+}
+
+// WebsocketRequestDetails are the details of an upgraded websocket connection.
+type WebsocketRequestDetails struct {
+	*HttpRequestDetails
+	Subprotocol string
+	Messages    []*WebsocketMessage
+	// Close describes why the connection was closed
+	Close string
+}
+
+func (d WebsocketRequestDetails) Type() RequestType {
+	return RequestTypeWebsocket
+}
+
+// SearchableContent contains the messages received from the client.
+func (d WebsocketRequestDetails) SearchableContent() []string {
+	content := d.HttpRequestDetails.SearchableContent()
+	for _, message := range d.Messages {
+		if !message.Sent {
+			content = append(content, string(message.Data))
+		}
+	}
+	return content
+}
+
+func (d WebsocketRequestDetails) Describe() string {
+	buffer := new(bytes.Buffer)
+	if d.Subprotocol != "" {
+		fmt.Fprintf(buffer, "> Subprotocol: %s\n", d.Subprotocol)
+	}
+	for i, message := range d.Messages {
+		direction := "received"
+		if message.Sent {
+			direction = "sent"
+		}
+		fmt.Fprintf(buffer, "> Message %d (%s %s, %d bytes):\n", i+1, direction, message.Time.Format(time.RFC3339Nano), len(message.Data))
+		if message.Binary {
+			buffer.WriteString(hex.Dump(message.Data))
+		} else {
+			buffer.Write(message.Data)
+			buffer.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(buffer, "> Close: %s\n\n", d.Close)
+	buffer.WriteString(d.HttpRequestDetails.Describe())
+	return buffer.String()
+}
+
+// WebsocketHandler accepts websocket upgrades from any origin and records the
+// messages in both directions until the client closes the connection or the
+// Duration passed. Other requests are passed to the Fallback.
+type WebsocketHandler struct {
+	// Duration limits the time the connection is kept open, defaults to DefaultWebsocketDuration
+	Duration time.Duration
+	// Echo sends every received message back to the client
+	Echo bool
+	// Messages are sent as text messages after the handshake
+	Messages []string
+	// MaxMessageSize limits the size of a received message, defaults to DefaultMaxBodySize
+	MaxMessageSize int64
+	Fallback       http.Handler
+}
+
+func (h *WebsocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		if h.Fallback == nil {
+			http.NotFound(w, r)
+			return
+		}
+		h.Fallback.ServeHTTP(w, r)
+		return
+	}
+	upgrader := websocket.Upgrader{
+		// cross-site connections are recorded as well
+		CheckOrigin:  func(r *http.Request) bool { return true },
+		Subprotocols: websocket.Subprotocols(r),
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		AddHttpNote(w, "websocket upgrade failed: "+err.Error())
+		return
+	}
+	defer conn.Close()
+	session := &websocketSession{conn: conn}
+	session.run(h)
+	WrapHttpDetails(w, func(details *HttpRequestDetails) RequestDetails {
+		// the handshake was written to the hijacked connection
+		details.Response.StatusCode = http.StatusSwitchingProtocols
+		details.Response.Status = http.StatusText(http.StatusSwitchingProtocols)
+		details.Response.Header.Set("Upgrade", "websocket")
+		details.Response.Header.Set("Connection", "Upgrade")
+		if conn.Subprotocol() != "" {
+			details.Response.Header.Set("Sec-Websocket-Protocol", conn.Subprotocol())
+		}
+		return &WebsocketRequestDetails{
+			HttpRequestDetails: details,
+			Subprotocol:        conn.Subprotocol(),
+			Messages:           session.messages,
+			Close:              session.close,
+		}
+	})
+}
+
+// websocketSession records the messages of a connection.
+type websocketSession struct {
+	conn     *websocket.Conn
+	messages []*WebsocketMessage
+	close    string
+}
+
+func (s *websocketSession) run(h *WebsocketHandler) {
+	duration := h.Duration
+	if duration <= 0 {
+		duration = DefaultWebsocketDuration
+	}
+	maxSize := h.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBodySize
+	}
+	deadline := time.Now().Add(duration)
+	s.conn.SetReadLimit(maxSize)
+	for _, message := range h.Messages {
+		if err := s.write(websocket.TextMessage, []byte(message)); err != nil {
+			s.close = "write failed: " + err.Error()
+			return
+		}
+	}
+	if err := s.conn.SetReadDeadline(deadline); err != nil {
+		s.close = err.Error()
+		return
+	}
+	for len(s.messages) < maxWebsocketMessages {
+		messageType, data, err := s.conn.ReadMessage()
+		if err != nil {
+			s.close = websocketCloseReason(err)
+			if isTimeout(err) {
+				_ = s.conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(websocketWriteWait))
+			}
+			return
+		}
+		s.messages = append(s.messages, &WebsocketMessage{
+			Time:   time.Now(),
+			Binary: messageType == websocket.BinaryMessage,
+			Data:   data,
+		})
+		if h.Echo {
+			if err := s.write(messageType, data); err != nil {
+				s.close = "write failed: " + err.Error()
+				return
+			}
+		}
+	}
+	s.close = "too many messages"
+	_ = s.conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.ClosePolicyViolation, s.close), time.Now().Add(websocketWriteWait))
+}
+
+func (s *websocketSession) write(messageType int, data []byte) error {
+	if err := s.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait)); err != nil {
+		return err
+	}
+	if err := s.conn.WriteMessage(messageType, data); err != nil {
+		return err
+	}
+	s.messages = append(s.messages, &WebsocketMessage{
+		Sent:   true,
+		Time:   time.Now(),
+		Binary: messageType == websocket.BinaryMessage,
+		Data:   data,
+	})
+	return nil
+}
+
+func isTimeout(err error) bool {
+	var netError net.Error
+	return errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netError) && netError.Timeout()
+}
+
+// websocketCloseReason describes the error which ended reading from the connection.
+func websocketCloseReason(err error) string {
+	var closeError *websocket.CloseError
+	switch {
+	case errors.As(err, &closeError):
+		return fmt.Sprintf("closed by client: %d %s", closeError.Code, closeError.Text)
+	case isTimeout(err):
+		return "duration exceeded"
+	case err == websocket.ErrReadLimit:
+		return "message too large"
+	}
+	return err.Error()
+}
@@ -0,0 +1,79 @@
+package ohren
+
+import (
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketBehindRules(t *testing.T) {
+	handler := &HttpRules{
+		Rules: []*HttpRule{{
+			Name:    "redirect",
+			Match:   HttpMatcher{Path: "/redirect"},
+			Handler: &HttpRedirect{StatusCode: http.StatusFound, Location: "http://example.com/"},
+		}},
+		Default: &WebsocketHandler{Echo: true, Duration: time.Second, Messages: []string{"hello"}},
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	records := make(chan Record, 10)
+	go func() {
+		_ = TcpListener{Listener: listener, Responder: &HttpHandlerResponder{Handler: handler}}.Record(records)
+	}()
+	url := "ws://" + listener.Addr().String()
+
+	// matching rules respond to upgrades
+	_, response, err := websocket.DefaultDialer.Dial(url+"/redirect", nil)
+	if err == nil || response == nil || response.StatusCode != http.StatusFound {
+		t.Fatalf("upgrade matching a rule: %v", err)
+	}
+	record := <-records
+	if details, ok := record.Details.(*HttpRequestDetails); !ok || details.Response.StatusCode != http.StatusFound {
+		t.Errorf("upgrade matching a rule recorded as %T", record.Details)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(url+"/other", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "hello" {
+		t.Fatalf("first message %q: %v", message, err)
+	}
+	if err := conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if _, message, err := conn.ReadMessage(); err != nil || string(message) != "ping" {
+		t.Fatalf("echo %q: %v", message, err)
+	}
+	_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye"))
+	record = <-records
+	conn.Close()
+	details, ok := record.Details.(*WebsocketRequestDetails)
+	if !ok {
+		t.Fatalf("websocket recorded as %T", record.Details)
+	}
+	var messages []string
+	for _, message := range details.Messages {
+		direction := "received "
+		if message.Sent {
+			direction = "sent "
+		}
+		messages = append(messages, direction+string(message.Data))
+	}
+	if strings.Join(messages, ", ") != "sent hello, received ping, sent ping" {
+		t.Errorf("recorded messages: %v", messages)
+	}
+	if details.Close != "closed by client: 1000 bye" || details.Response.StatusCode != http.StatusSwitchingProtocols {
+		t.Errorf("recorded close %q and status %d", details.Close, details.Response.StatusCode)
+	}
+}
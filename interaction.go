@@ -0,0 +1,56 @@
+package ohren
+
+import "time"
+
+// Interaction is the serializable form of a RecordedConnection
+type Interaction struct {
+	ID            uint64    `json:"id"`
+	Type          string    `json:"type"`
+	Protocol      string    `json:"protocol,omitempty"`
+	Description   string    `json:"description"`
+	Hosts         []string  `json:"hosts"`
+	Tokens        []string  `json:"tokens"`
+	Owner         string    `json:"owner,omitempty"`
+	StartTime     time.Time `json:"start_time"`
+	EndTime       time.Time `json:"end_time"`
+	RemoteAddress string    `json:"client_address"`
+	RemotePort    int       `json:"client_port"`
+	LocalAddress  string    `json:"local_address"`
+	LocalPort     int       `json:"local_port"`
+	// ConnectionID links the interactions of requests on the same connection
+	ConnectionID  string `json:"connection_id,omitempty"`
+	RequestNumber int    `json:"request_number,omitempty"`
+	// Body is the captured request body, if any
+	Body *CapturedBody `json:"body,omitempty"`
+}
+
+// NewInteraction converts the record. It returns nil if the record has no details.
+func NewInteraction(record Record) *Interaction {
+	details := record.Details
+	if details == nil {
+		return nil
+	}
+	interaction := &Interaction{
+		ID:            record.ID,
+		Type:          string(details.Type()),
+		Description:   details.Describe(),
+		Hosts:         details.Hosts(),
+		Tokens:        record.Tokens,
+		Owner:         record.Owner,
+		StartTime:     record.StartTime,
+		EndTime:       record.EndTime,
+		RemoteAddress: record.RemoteAddress,
+		RemotePort:    record.RemotePort,
+		LocalAddress:  record.LocalAddress,
+		LocalPort:     record.LocalPort,
+		ConnectionID:  record.ConnectionID,
+		RequestNumber: record.RequestNumber,
+	}
+	if protocolDetails, ok := details.(ProtocolDetails); ok {
+		interaction.Protocol = protocolDetails.RequestProtocol()
+	}
+	if bodyDetails, ok := details.(BodyDetails); ok {
+		interaction.Body = bodyDetails.RequestBody()
+	}
+	return interaction
+}
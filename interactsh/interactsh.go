@@ -0,0 +1,370 @@
+// Package interactsh implements the server side of the interactsh client protocol
+// so that interactsh clients can poll the interactions recorded by ohren.
+package interactsh
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
+	"github.com/miekg/dns"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultCorrelationIDLength is the correlation ID length used by the interactsh clients.
+const DefaultCorrelationIDLength = 20
+
+// maxQueuedInteractions is the maximum amount of interactions kept for a client between two polls.
+const maxQueuedInteractions = 1000
+
+type registerRequest struct {
+	PublicKey     string `json:"public-key"`
+	SecretKey     string `json:"secret-key"`
+	CorrelationID string `json:"correlation-id"`
+}
+
+type deregisterRequest struct {
+	SecretKey     string `json:"secret-key"`
+	CorrelationID string `json:"correlation-id"`
+}
+
+type pollResponse struct {
+	Data    []string `json:"data"`
+	Extra   []string `json:"extra"`
+	AESKey  string   `json:"aes_key"`
+	TLDData []string `json:"tld_data,omitempty"`
+}
+
+type messageResponse struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Interaction is the format in which interactsh clients expect an interaction.
+type Interaction struct {
+	Protocol      string    `json:"protocol"`
+	UniqueID      string    `json:"unique-id"`
+	FullID        string    `json:"full-id"`
+	QType         string    `json:"q-type,omitempty"`
+	RawRequest    string    `json:"raw-request,omitempty"`
+	RawResponse   string    `json:"raw-response,omitempty"`
+	RemoteAddress string    `json:"remote-address"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+type session struct {
+	// owner is the user which registered the session, empty for administrators
+	owner           string
+	secret          string
+	aesKey          []byte
+	encryptedAESKey string
+	data            []string
+}
+
+// Server keeps the registered interactsh clients and the interactions matching
+// their correlation IDs until they are polled.
+type Server struct {
+	// Domain is the domain below which the interactsh payloads are generated
+	Domain string
+	// CorrelationIDLength is the length of the correlation ID configured in the clients
+	CorrelationIDLength int
+	// Tokens provides the namespaces of the users. If it is set, registrations of
+	// users are answered with the namespace to use as server domain.
+	Tokens *ohren.TokenRegistry
+	mutex               sync.Mutex
+	sessions            map[string]*session
+	mux                 *http.ServeMux
+}
+
+func NewServer(domain string) *Server {
+	server := &Server{
+		Domain:              ohren.NormalizeHost(domain),
+		CorrelationIDLength: DefaultCorrelationIDLength,
+		sessions:            make(map[string]*session),
+		mux:                 http.NewServeMux(),
+	}
+	server.mux.HandleFunc("/register", server.register)
+	server.mux.HandleFunc("/deregister", server.deregister)
+	server.mux.HandleFunc("/poll", server.poll)
+	return server
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) register(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var request registerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "could not decode request")
+		return
+	}
+	correlationID := strings.ToLower(request.CorrelationID)
+	if len(correlationID) != s.CorrelationIDLength || request.SecretKey == "" {
+		writeError(w, http.StatusBadRequest, "invalid correlation-id or secret-key")
+		return
+	}
+	publicKey, err := parsePublicKey(request.PublicKey)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	aesKey := make([]byte, 32)
+	if _, err := rand.Read(aesKey); err != nil {
+		writeError(w, http.StatusInternalServerError, "could not generate key")
+		return
+	}
+	encryptedAESKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, aesKey, nil)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "could not encrypt key")
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if existing, ok := s.sessions[correlationID]; ok && existing.secret != request.SecretKey {
+		writeError(w, http.StatusConflict, "correlation-id already registered")
+		return
+	}
+	var owner string
+	if user := auth.UserFromRequest(r); user != nil {
+		owner = user.Owner()
+	}
+	s.sessions[correlationID] = &session{
+		owner:           owner,
+		secret:          request.SecretKey,
+		aesKey:          aesKey,
+		encryptedAESKey: base64.StdEncoding.EncodeToString(encryptedAESKey),
+	}
+	log.Printf("interactsh client registered: %s", correlationID)
+	message := "registration successful"
+	if owner != "" && s.Tokens != nil {
+		// only records sent to the namespace are owned by the user
+		message += ", use " + s.Tokens.Namespace(owner) + " as server domain"
+	}
+	writeJSON(w, http.StatusOK, &messageResponse{Message: message})
+}
+
+func (s *Server) deregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	var request deregisterRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, "could not decode request")
+		return
+	}
+	correlationID := strings.ToLower(request.CorrelationID)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.sessions[correlationID]
+	if !ok || existing.secret != request.SecretKey {
+		writeError(w, http.StatusUnauthorized, "invalid correlation-id or secret-key")
+		return
+	}
+	delete(s.sessions, correlationID)
+	log.Printf("interactsh client deregistered: %s", correlationID)
+	writeJSON(w, http.StatusOK, &messageResponse{Message: "deregistration successful"})
+}
+
+func (s *Server) poll(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	correlationID := strings.ToLower(r.URL.Query().Get("id"))
+	secret := r.URL.Query().Get("secret")
+	s.mutex.Lock()
+	existing, ok := s.sessions[correlationID]
+	if !ok || existing.secret != secret {
+		s.mutex.Unlock()
+		writeError(w, http.StatusUnauthorized, "invalid id or secret")
+		return
+	}
+	response := &pollResponse{
+		Data:   existing.data,
+		Extra:  []string{},
+		AESKey: existing.encryptedAESKey,
+	}
+	existing.data = nil
+	s.mutex.Unlock()
+	if response.Data == nil {
+		response.Data = []string{}
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// Record queues all records from in for the clients whose correlation ID they contain.
+// Records owned by a user are only queued for sessions of that user or of
+// administrators, records without owner only for sessions of administrators.
+// Clients of users therefore have to use the namespace of the user as server
+// domain, e.g. <correlation id>.<namespace>.<domain>.
+func (s *Server) Record(in chan ohren.Record) {
+	for record := range in {
+		if record.Details == nil {
+			continue
+		}
+		for correlationID, uniqueID := range s.match(record.Details) {
+			interaction := s.newInteraction(record, uniqueID)
+			message, err := json.Marshal(interaction)
+			if err != nil {
+				log.Printf("failed to encode interaction: %s", err)
+				continue
+			}
+			s.queue(correlationID, record.Owner, message)
+		}
+	}
+}
+
+func (s *Server) queue(correlationID string, owner string, message []byte) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	existing, ok := s.sessions[correlationID]
+	if !ok {
+		return
+	}
+	// administrators have no owner and receive all records
+	if existing.owner != "" && owner != existing.owner {
+		return
+	}
+	encrypted, err := encrypt(existing.aesKey, message)
+	if err != nil {
+		log.Printf("failed to encrypt interaction: %s", err)
+		return
+	}
+	existing.data = append(existing.data, encrypted)
+	if len(existing.data) > maxQueuedInteractions {
+		existing.data = existing.data[len(existing.data)-maxQueuedInteractions:]
+	}
+}
+
+// match returns the unique IDs found in the details by the correlation IDs
+// of registered clients.
+func (s *Server) match(details ohren.RequestDetails) map[string]string {
+	content := append([]string{}, details.Hosts()...)
+	if searchable, ok := details.(ohren.SearchableDetails); ok {
+		content = append(content, searchable.SearchableContent()...)
+	}
+	matches := make(map[string]string)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if len(s.sessions) == 0 {
+		return matches
+	}
+	for _, c := range content {
+		words := strings.FieldsFunc(strings.ToLower(c), func(r rune) bool {
+			return !('a' <= r && r <= 'z' || '0' <= r && r <= '9')
+		})
+		for _, word := range words {
+			if len(word) < s.CorrelationIDLength {
+				continue
+			}
+			correlationID := word[:s.CorrelationIDLength]
+			if _, ok := s.sessions[correlationID]; ok {
+				if _, found := matches[correlationID]; !found {
+					matches[correlationID] = word
+				}
+			}
+		}
+	}
+	return matches
+}
+
+func (s *Server) newInteraction(record ohren.Record, uniqueID string) *Interaction {
+	interaction := &Interaction{
+		UniqueID:      uniqueID,
+		FullID:        uniqueID,
+		RemoteAddress: record.RemoteAddress,
+		Timestamp:     record.StartTime,
+	}
+	for _, host := range record.Details.Hosts() {
+		host = ohren.NormalizeHost(host)
+		if strings.Contains(host, uniqueID) && s.Domain != "" && strings.HasSuffix(host, "."+s.Domain) {
+			interaction.FullID = strings.TrimSuffix(host, "."+s.Domain)
+			break
+		}
+	}
+	if raw, ok := record.Details.(ohren.RawDetails); ok {
+		interaction.RawRequest = raw.RawRequest()
+		interaction.RawResponse = raw.RawResponse()
+	}
+	switch details := record.Details.(type) {
+	case ohren.DnsRequestDetails:
+		interaction.Protocol = "dns"
+		if details.Request != nil && len(details.Request.Question) > 0 {
+			interaction.QType = dns.TypeToString[details.Request.Question[0].Qtype]
+		}
+	case *ohren.HttpRequestDetails, ohren.HttpRequestDetails, *ohren.GrpcRequestDetails, *ohren.WebsocketRequestDetails:
+		interaction.Protocol = "http"
+	default:
+		interaction.Protocol = strings.ToLower(string(record.Details.Type()))
+	}
+	return interaction
+}
+
+func parsePublicKey(encoded string) (*rsa.PublicKey, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("invalid public-key encoding")
+	}
+	block, _ := pem.Decode(decoded)
+	if block == nil {
+		return nil, errors.New("invalid public-key pem")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, errors.New("invalid public-key")
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public-key is not an RSA key")
+	}
+	return publicKey, nil
+}
+
+// encrypt encrypts the message with AES-CFB and returns the IV followed by the
+// cipher text as base64.
+func encrypt(key []byte, message []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	cipherText := make([]byte, aes.BlockSize+len(message))
+	iv := cipherText[:aes.BlockSize]
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return "", err
+	}
+	stream := cipher.NewCFBEncrypter(block, iv)
+	stream.XORKeyStream(cipherText[aes.BlockSize:], message)
+	return base64.StdEncoding.EncodeToString(cipherText), nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Printf("failed to write response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, &messageResponse{Error: message})
+}
@@ -0,0 +1,257 @@
+package interactsh
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
+	"github.com/miekg/dns"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+const (
+	testCorrelationID = "c0rrelation0id0abcde"
+	testSecret        = "secret"
+	adminKey          = "admin-key"
+	aliceKey          = "alice-key"
+)
+
+type testClient struct {
+	key     *rsa.PrivateKey
+	handler http.Handler
+	apiKey  string
+}
+
+func newTestClient(t *testing.T, handler http.Handler, apiKey string) *testClient {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testClient{key: key, handler: handler, apiKey: apiKey}
+}
+
+func (c *testClient) request(t *testing.T, method string, target string, body interface{}) *httptest.ResponseRecorder {
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := httptest.NewRequest(method, target, bytes.NewReader(encoded))
+	r.Header.Set("X-API-Key", c.apiKey)
+	w := httptest.NewRecorder()
+	c.handler.ServeHTTP(w, r)
+	return w
+}
+
+// register registers the client and returns the message of the response.
+func (c *testClient) register(t *testing.T) string {
+	der, err := x509.MarshalPKIXPublicKey(&c.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: der})
+	w := c.request(t, http.MethodPost, "/register", &registerRequest{
+		PublicKey:     base64.StdEncoding.EncodeToString(publicKey),
+		SecretKey:     testSecret,
+		CorrelationID: testCorrelationID,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("register: %d %s", w.Code, w.Body.String())
+	}
+	var response messageResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	return response.Message
+}
+
+// poll returns the decrypted interactions like an interactsh client.
+func (c *testClient) poll(t *testing.T) []*Interaction {
+	w := c.request(t, http.MethodGet, "/poll?id="+testCorrelationID+"&secret="+testSecret, nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("poll: %d %s", w.Code, w.Body.String())
+	}
+	var response pollResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	encryptedKey, err := base64.StdEncoding.DecodeString(response.AESKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aesKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, c.key, encryptedKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var interactions []*Interaction
+	for _, data := range response.Data {
+		decoded, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) < aes.BlockSize {
+			t.Fatalf("data too short: %d bytes", len(decoded))
+		}
+		message := decoded[aes.BlockSize:]
+		cipher.NewCFBDecrypter(block, decoded[:aes.BlockSize]).XORKeyStream(message, message)
+		interaction := new(Interaction)
+		if err := json.Unmarshal(message, interaction); err != nil {
+			t.Fatalf("invalid interaction %q: %s", message, err)
+		}
+		interactions = append(interactions, interaction)
+	}
+	return interactions
+}
+
+func newTestHandler(server *Server) http.Handler {
+	authenticator := auth.NewAuthenticator([]string{adminKey})
+	authenticator.AddUser("alice", "", []string{aliceKey})
+	return authenticator.Require(server)
+}
+
+func dnsRecord(name string, owner string) ohren.Record {
+	request := new(dns.Msg)
+	request.SetQuestion(dns.Fqdn(name), dns.TypeA)
+	response := new(dns.Msg)
+	response.SetReply(request)
+	return &ohren.RecordedConnection{
+		RemoteAddress: "192.0.2.1",
+		StartTime:     time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Details: ohren.DnsRequestDetails{
+			RequestedHosts: []string{name},
+			Request:        request,
+			Response:       response,
+		},
+		Owner: owner,
+	}
+}
+
+func record(server *Server, records ...ohren.Record) {
+	in := make(chan ohren.Record, len(records))
+	for _, r := range records {
+		in <- r
+	}
+	close(in)
+	server.Record(in)
+}
+
+func TestRegisterPollRoundTrip(t *testing.T) {
+	server := NewServer("oast.example")
+	client := newTestClient(t, newTestHandler(server), adminKey)
+	client.register(t)
+	if interactions := client.poll(t); len(interactions) != 0 {
+		t.Fatalf("interactions before any record: %v", interactions)
+	}
+
+	uniqueID := testCorrelationID + "nonce0abcdefg"
+	record(server,
+		dnsRecord("x."+uniqueID+".oast.example", ""),
+		dnsRecord("unrelated.oast.example", ""),
+	)
+	interactions := client.poll(t)
+	if len(interactions) != 1 {
+		t.Fatalf("got %d interactions, want 1", len(interactions))
+	}
+	interaction := interactions[0]
+	if interaction.Protocol != "dns" || interaction.QType != "A" {
+		t.Errorf("protocol %q, q-type %q", interaction.Protocol, interaction.QType)
+	}
+	if interaction.UniqueID != uniqueID {
+		t.Errorf("unique-id = %q, want %q", interaction.UniqueID, uniqueID)
+	}
+	if want := "x." + uniqueID; interaction.FullID != want {
+		t.Errorf("full-id = %q, want %q", interaction.FullID, want)
+	}
+	if interaction.RemoteAddress != "192.0.2.1" || interaction.RawRequest == "" {
+		t.Errorf("remote-address %q, raw-request %q", interaction.RemoteAddress, interaction.RawRequest)
+	}
+	if interactions := client.poll(t); len(interactions) != 0 {
+		t.Errorf("interactions are polled twice: %v", interactions)
+	}
+}
+
+func TestRegisterTakenCorrelationID(t *testing.T) {
+	server := NewServer("oast.example")
+	handler := newTestHandler(server)
+	newTestClient(t, handler, adminKey).register(t)
+	other := newTestClient(t, handler, adminKey)
+	der, _ := x509.MarshalPKIXPublicKey(&other.key.PublicKey)
+	w := other.request(t, http.MethodPost, "/register", &registerRequest{
+		PublicKey:     base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})),
+		SecretKey:     "other",
+		CorrelationID: testCorrelationID,
+	})
+	if w.Code != http.StatusConflict {
+		t.Errorf("register = %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := other.request(t, http.MethodGet, "/poll?id="+testCorrelationID+"&secret=other", nil); w.Code != http.StatusUnauthorized {
+		t.Errorf("poll with wrong secret = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestQueueOwner(t *testing.T) {
+	tests := []struct {
+		name        string
+		apiKey      string
+		recordOwner string
+		received    bool
+	}{
+		{name: "admin receives unowned", apiKey: adminKey, recordOwner: "", received: true},
+		{name: "admin receives owned", apiKey: adminKey, recordOwner: "alice", received: true},
+		{name: "user receives own", apiKey: aliceKey, recordOwner: "alice", received: true},
+		{name: "user misses unowned", apiKey: aliceKey, recordOwner: "", received: false},
+		{name: "user misses foreign", apiKey: aliceKey, recordOwner: "bob", received: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer("oast.example")
+			client := newTestClient(t, newTestHandler(server), test.apiKey)
+			client.register(t)
+			record(server, dnsRecord(testCorrelationID+"nonce0abcdefg.oast.example", test.recordOwner))
+			if received := len(client.poll(t)) == 1; received != test.received {
+				t.Errorf("received = %v, want %v", received, test.received)
+			}
+		})
+	}
+}
+
+func TestRegisterNamespaceHint(t *testing.T) {
+	tokens := ohren.NewTokenRegistry("oast.example")
+	tokens.SetNamespace("alice", "a")
+	tests := []struct {
+		name    string
+		apiKey  string
+		tokens  *ohren.TokenRegistry
+		message string
+	}{
+		{name: "admin", apiKey: adminKey, tokens: tokens, message: "registration successful"},
+		{name: "user", apiKey: aliceKey, tokens: tokens, message: "registration successful, use a.oast.example as server domain"},
+		{name: "user without namespaces", apiKey: aliceKey, message: "registration successful"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewServer("oast.example")
+			server.Tokens = test.tokens
+			if message := newTestClient(t, newTestHandler(server), test.apiKey).register(t); message != test.message {
+				t.Errorf("message %q, want %q", message, test.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/coffeemakr/ohren"
+	"log"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMinMailInterval     = 1 * time.Minute
+	defaultMaxMailInteractions = 50
+)
+
+// Email sends the accepted interactions through an SMTP relay. Interactions are
+// collected for BatchInterval and sent as a digest. At most one mail is sent per
+// MinInterval, interactions arriving in between are added to the next mail.
+type Email struct {
+	// Address of the SMTP relay (host:port)
+	Address string
+	// Auth is used if the relay supports authentication
+	Auth   smtp.Auth
+	From   string
+	To     []string
+	Filter Filter
+	// BatchInterval is the time to wait for further interactions before sending a mail
+	BatchInterval time.Duration
+	// MinInterval is the minimum time between two mails
+	MinInterval time.Duration
+	// MaxInteractions is the maximum amount of interactions described in one mail.
+	// Further interactions are only counted.
+	MaxInteractions int
+
+	mutex    sync.Mutex
+	pending  []*ohren.Interaction
+	omitted  int
+	lastSent time.Time
+	timer    *time.Timer
+	// send delivers the mail, defaults to smtp.SendMail
+	send func(address string, auth smtp.Auth, from string, to []string, message []byte) error
+}
+
+func NewEmail(address string, from string, to []string) *Email {
+	return &Email{
+		Address:         address,
+		From:            from,
+		To:              to,
+		MinInterval:     defaultMinMailInterval,
+		MaxInteractions: defaultMaxMailInteractions,
+	}
+}
+
+// SetPlainAuth authenticates with the username and password.
+func (e *Email) SetPlainAuth(username string, password string) error {
+	host, _, err := net.SplitHostPort(e.Address)
+	if err != nil {
+		return err
+	}
+	e.Auth = smtp.PlainAuth("", username, password, host)
+	return nil
+}
+
+func (e *Email) Accepts(interaction *ohren.Interaction) bool {
+	return e.Filter.Matches(interaction)
+}
+
+// Notify queues the interaction for the next mail and never blocks.
+func (e *Email) Notify(interaction *ohren.Interaction) error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if e.MaxInteractions > 0 && len(e.pending) >= e.MaxInteractions {
+		e.omitted++
+	} else {
+		e.pending = append(e.pending, interaction)
+	}
+	if e.timer == nil {
+		delay := e.BatchInterval
+		if wait := time.Until(e.lastSent.Add(e.MinInterval)); wait > delay {
+			delay = wait
+		}
+		e.timer = time.AfterFunc(delay, e.flush)
+	}
+	return nil
+}
+
+func (e *Email) flush() {
+	e.mutex.Lock()
+	interactions := e.pending
+	omitted := e.omitted
+	e.pending = nil
+	e.omitted = 0
+	e.timer = nil
+	e.lastSent = time.Now()
+	e.mutex.Unlock()
+
+	if len(interactions) == 0 {
+		return
+	}
+	message, err := e.message(interactions, omitted)
+	if err != nil {
+		log.Printf("failed to create mail: %s", err)
+		return
+	}
+	send := e.send
+	if send == nil {
+		send = smtp.SendMail
+	}
+	if err := send(e.Address, e.Auth, e.From, e.To, message); err != nil {
+		log.Printf("failed to send mail to %s: %s", strings.Join(e.To, ", "), err)
+	}
+}
+
+func (e *Email) message(interactions []*ohren.Interaction, omitted int) ([]byte, error) {
+	total := len(interactions) + omitted
+	subject := fmt.Sprintf("[ohren] %s from %s", interactions[0].Type, interactions[0].RemoteAddress)
+	if total > 1 {
+		subject = fmt.Sprintf("[ohren] %d new interactions", total)
+	}
+
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "From: %s\r\n", e.From)
+	fmt.Fprintf(buffer, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(buffer, "Subject: %s\r\n", subject)
+	fmt.Fprintf(buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+
+	body := quotedprintable.NewWriter(buffer)
+	for _, interaction := range interactions {
+		fmt.Fprintf(body, "#%d %s from %s:%d to %s:%d at %s\n",
+			interaction.ID, interaction.Type,
+			interaction.RemoteAddress, interaction.RemotePort,
+			interaction.LocalAddress, interaction.LocalPort,
+			interaction.StartTime.Format(time.RFC3339))
+		if len(interaction.Hosts) > 0 {
+			fmt.Fprintf(body, "Hosts: %s\n", strings.Join(interaction.Hosts, ", "))
+		}
+		if len(interaction.Tokens) > 0 {
+			fmt.Fprintf(body, "Tokens: %s\n", strings.Join(interaction.Tokens, ", "))
+		}
+		fmt.Fprintf(body, "\n%s\n\n", interaction.Description)
+	}
+	if omitted > 0 {
+		fmt.Fprintf(body, "%d further interactions were omitted.\n", omitted)
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
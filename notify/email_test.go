@@ -0,0 +1,166 @@
+package notify
+
+import (
+	"bytes"
+	"github.com/coffeemakr/ohren"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net/smtp"
+	"strings"
+	"testing"
+	"time"
+)
+
+type sentMail struct {
+	from    string
+	to      []string
+	subject string
+	body    string
+	time    time.Time
+}
+
+// fakeSend returns a send function passing the mails to the channel.
+func fakeSend(t *testing.T, mails chan *sentMail) func(string, smtp.Auth, string, []string, []byte) error {
+	return func(address string, auth smtp.Auth, from string, to []string, message []byte) error {
+		parts := bytes.SplitN(message, []byte("\r\n\r\n"), 2)
+		if len(parts) != 2 {
+			t.Errorf("mail without body: %q", message)
+			return nil
+		}
+		mail := &sentMail{from: from, to: to, time: time.Now()}
+		for _, line := range strings.Split(string(parts[0]), "\r\n") {
+			if strings.HasPrefix(line, "Subject: ") {
+				mail.subject = strings.TrimPrefix(line, "Subject: ")
+			}
+		}
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(parts[1])))
+		if err != nil {
+			t.Errorf("invalid body: %s", err)
+		}
+		// the quoted-printable writer sends CRLF line endings
+		mail.body = strings.ReplaceAll(string(body), "\r\n", "\n")
+		mails <- mail
+		return nil
+	}
+}
+
+func testInteraction(id uint64) *ohren.Interaction {
+	return &ohren.Interaction{
+		ID:            id,
+		Type:          string(ohren.RequestTypeDNS),
+		Description:   "description of " + strings.Repeat("=", int(id)),
+		Hosts:         []string{"abc.example.com"},
+		Tokens:        []string{"abc"},
+		RemoteAddress: "192.0.2.1",
+		RemotePort:    5353,
+		LocalAddress:  "192.0.2.2",
+		LocalPort:     53,
+		StartTime:     time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestEmailDigest(t *testing.T) {
+	tests := []struct {
+		name            string
+		interactions    int
+		maxInteractions int
+		subject         string
+		contains        []string
+		omits           []string
+	}{
+		{
+			name: "single", interactions: 1, maxInteractions: 10,
+			subject: "[ohren] DNS request from 192.0.2.1",
+			contains: []string{
+				"#1 DNS request from 192.0.2.1:5353 to 192.0.2.2:53 at 2020-01-02T03:04:05Z\n",
+				"Hosts: abc.example.com\n", "Tokens: abc\n", "description of =\n",
+			},
+			omits: []string{"omitted"},
+		},
+		{
+			name: "batch", interactions: 3, maxInteractions: 10,
+			subject:  "[ohren] 3 new interactions",
+			contains: []string{"#1 DNS", "#2 DNS", "#3 DNS", "description of ===\n"},
+			omits:    []string{"omitted"},
+		},
+		{
+			name: "limited", interactions: 4, maxInteractions: 2,
+			subject:  "[ohren] 4 new interactions",
+			contains: []string{"#1 DNS", "#2 DNS", "2 further interactions were omitted.\n"},
+			omits:    []string{"#3 DNS", "#4 DNS"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mails := make(chan *sentMail, 10)
+			email := NewEmail("mail.example.com:25", "ohren@example.com", []string{"a@example.com", "b@example.com"})
+			email.BatchInterval = 50 * time.Millisecond
+			email.MinInterval = 0
+			email.MaxInteractions = test.maxInteractions
+			email.send = fakeSend(t, mails)
+			for i := 1; i <= test.interactions; i++ {
+				if err := email.Notify(testInteraction(uint64(i))); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var mail *sentMail
+			select {
+			case mail = <-mails:
+			case <-time.After(5 * time.Second):
+				t.Fatal("no mail sent")
+			}
+			select {
+			case <-mails:
+				t.Error("interactions of one batch sent in several mails")
+			case <-time.After(100 * time.Millisecond):
+			}
+			if mail.from != "ohren@example.com" || strings.Join(mail.to, ",") != "a@example.com,b@example.com" {
+				t.Errorf("mail from %s to %v", mail.from, mail.to)
+			}
+			if mail.subject != test.subject {
+				t.Errorf("subject %q, want %q", mail.subject, test.subject)
+			}
+			for _, text := range test.contains {
+				if !strings.Contains(mail.body, text) {
+					t.Errorf("body does not contain %q:\n%s", text, mail.body)
+				}
+			}
+			for _, text := range test.omits {
+				if strings.Contains(mail.body, text) {
+					t.Errorf("body contains %q:\n%s", text, mail.body)
+				}
+			}
+		})
+	}
+}
+
+func TestEmailMinInterval(t *testing.T) {
+	mails := make(chan *sentMail, 10)
+	email := NewEmail("mail.example.com:25", "ohren@example.com", []string{"a@example.com"})
+	email.BatchInterval = 10 * time.Millisecond
+	email.MinInterval = 300 * time.Millisecond
+	email.send = fakeSend(t, mails)
+	receive := func() *sentMail {
+		select {
+		case mail := <-mails:
+			return mail
+		case <-time.After(5 * time.Second):
+			t.Fatal("no mail sent")
+			return nil
+		}
+	}
+
+	_ = email.Notify(testInteraction(1))
+	first := receive()
+	// both interactions arrive before the minimum interval passed
+	_ = email.Notify(testInteraction(2))
+	time.Sleep(50 * time.Millisecond)
+	_ = email.Notify(testInteraction(3))
+	second := receive()
+	if wait := second.time.Sub(first.time); wait < 250*time.Millisecond {
+		t.Errorf("second mail sent %s after the first", wait)
+	}
+	if second.subject != "[ohren] 2 new interactions" {
+		t.Errorf("second mail has subject %q", second.subject)
+	}
+}
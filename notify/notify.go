@@ -0,0 +1,117 @@
+// Package notify sends notifications about new interactions to external services.
+package notify
+
+import (
+	"github.com/coffeemakr/ohren"
+	"log"
+	"path"
+)
+
+// queueSize is the amount of interactions queued for a notifier before new ones are dropped.
+const queueSize = 256
+
+// Notifier delivers interactions to an external service.
+type Notifier interface {
+	// Accepts returns true if the notifier wants to be notified about the interaction.
+	Accepts(interaction *ohren.Interaction) bool
+	// Notify delivers the interaction. It may block until the delivery succeeded or failed.
+	Notify(interaction *ohren.Interaction) error
+}
+
+// Filter selects interactions. Empty fields match all interactions.
+type Filter struct {
+	// Types are matched like ohren.Query.Type
+	Types []string
+	// Tokens matches interactions tagged with one of the tokens
+	Tokens []string
+	// Hosts are glob patterns (e.g. "*.example.com") matched against the hosts
+	Hosts []string
+	// Owner only matches interactions of the owner
+	Owner string
+}
+
+func (f *Filter) Matches(interaction *ohren.Interaction) bool {
+	if f.Owner != "" && interaction.Owner != f.Owner {
+		return false
+	}
+	if len(f.Types) > 0 && !f.matchesType(interaction) {
+		return false
+	}
+	if len(f.Tokens) > 0 && !f.matchesToken(interaction) {
+		return false
+	}
+	if len(f.Hosts) > 0 && !f.matchesHost(interaction) {
+		return false
+	}
+	return true
+}
+
+func (f *Filter) matchesType(interaction *ohren.Interaction) bool {
+	for _, t := range f.Types {
+		if ohren.MatchesType(interaction.Type, t) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchesToken(interaction *ohren.Interaction) bool {
+	for _, token := range f.Tokens {
+		for _, tagged := range interaction.Tokens {
+			if token == tagged {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (f *Filter) matchesHost(interaction *ohren.Interaction) bool {
+	for _, pattern := range f.Hosts {
+		pattern = ohren.NormalizeHost(pattern)
+		for _, host := range interaction.Hosts {
+			if matched, _ := path.Match(pattern, ohren.NormalizeHost(host)); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Run passes the interactions of all records from in to the notifiers accepting
+// them. Every notifier gets its own queue, so a slow notifier neither blocks
+// the others nor the records. Run returns when in was closed.
+func Run(in chan ohren.Record, notifiers []Notifier) {
+	queues := make([]chan *ohren.Interaction, len(notifiers))
+	for i, notifier := range notifiers {
+		queues[i] = make(chan *ohren.Interaction, queueSize)
+		go deliver(notifier, queues[i])
+	}
+	for record := range in {
+		interaction := ohren.NewInteraction(record)
+		if interaction == nil {
+			continue
+		}
+		for i, notifier := range notifiers {
+			if !notifier.Accepts(interaction) {
+				continue
+			}
+			select {
+			case queues[i] <- interaction:
+			default:
+				log.Printf("notification queue full, dropping interaction %d", interaction.ID)
+			}
+		}
+	}
+	for _, queue := range queues {
+		close(queue)
+	}
+}
+
+func deliver(notifier Notifier, queue chan *ohren.Interaction) {
+	for interaction := range queue {
+		if err := notifier.Notify(interaction); err != nil {
+			log.Printf("notification failed: %s", err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package notify
+
+import (
+	"github.com/coffeemakr/ohren"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFilter(t *testing.T) {
+	interaction := &ohren.Interaction{
+		Type:   string(ohren.RequestTypeDNS),
+		Hosts:  []string{"abc.Example.com."},
+		Tokens: []string{"abc"},
+		Owner:  "red",
+	}
+	tests := []struct {
+		name    string
+		filter  Filter
+		matches bool
+	}{
+		{name: "empty", matches: true},
+		{name: "type", filter: Filter{Types: []string{"http", "dns"}}, matches: true},
+		{name: "other type", filter: Filter{Types: []string{"http"}}},
+		{name: "token", filter: Filter{Tokens: []string{"abc"}}, matches: true},
+		{name: "other token", filter: Filter{Tokens: []string{"abd"}}},
+		{name: "host", filter: Filter{Hosts: []string{"*.example.com"}}, matches: true},
+		{name: "other host", filter: Filter{Hosts: []string{"*.example.org"}}},
+		{name: "owner", filter: Filter{Owner: "red"}, matches: true},
+		{name: "other owner", filter: Filter{Owner: "blue"}},
+		{name: "all fields", filter: Filter{Types: []string{"dns"}, Tokens: []string{"abc"}, Hosts: []string{"abc.example.com"}, Owner: "red"}, matches: true},
+		{name: "one field differs", filter: Filter{Types: []string{"dns"}, Tokens: []string{"abc"}, Owner: "blue"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := test.filter.Matches(interaction); matches != test.matches {
+				t.Errorf("Matches = %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+// recordingNotifier collects the notified interactions.
+type recordingNotifier struct {
+	Filter
+	mutex        sync.Mutex
+	interactions []*ohren.Interaction
+	done         chan struct{}
+}
+
+func (n *recordingNotifier) Accepts(interaction *ohren.Interaction) bool {
+	return n.Filter.Matches(interaction)
+}
+
+func (n *recordingNotifier) Notify(interaction *ohren.Interaction) error {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	n.interactions = append(n.interactions, interaction)
+	if interaction.Type == "last" {
+		close(n.done)
+	}
+	return nil
+}
+
+type typeDetails string
+
+func (d typeDetails) Type() ohren.RequestType { return ohren.RequestType(d) }
+func (d typeDetails) Describe() string        { return string(d) }
+func (d typeDetails) Hosts() []string         { return nil }
+
+func TestRun(t *testing.T) {
+	dns := &recordingNotifier{Filter: Filter{Types: []string{"dns", "last"}}, done: make(chan struct{})}
+	all := &recordingNotifier{done: make(chan struct{})}
+	in := make(chan ohren.Record)
+	go Run(in, []Notifier{dns, all})
+	for _, details := range []ohren.RequestDetails{
+		typeDetails(ohren.RequestTypeHttp), typeDetails(ohren.RequestTypeDNS), nil, typeDetails("last"),
+	} {
+		in <- &ohren.RecordedConnection{Details: details}
+	}
+	close(in)
+	for _, notifier := range []*recordingNotifier{dns, all} {
+		select {
+		case <-notifier.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("interactions not delivered")
+		}
+	}
+	if len(dns.interactions) != 2 || dns.interactions[0].Type != string(ohren.RequestTypeDNS) {
+		t.Errorf("filtered notifier got %d interactions", len(dns.interactions))
+	}
+	// records without details are no interactions
+	if len(all.interactions) != 3 {
+		t.Errorf("notifier without filter got %d interactions", len(all.interactions))
+	}
+}
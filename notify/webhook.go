@@ -0,0 +1,109 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/coffeemakr/ohren"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// SignatureHeader contains the hex encoded HMAC-SHA256 of the body prefixed by "sha256=".
+const SignatureHeader = "X-Ohren-Signature"
+
+const (
+	defaultMaxRetries     = 3
+	defaultInitialBackoff = 1 * time.Second
+	maxBackoff            = 5 * time.Minute
+	webhookTimeout        = 10 * time.Second
+)
+
+// Webhook POSTs every accepted interaction as JSON to the URL.
+type Webhook struct {
+	URL    string
+	Filter Filter
+	// Secret is used to sign the body. No signature is sent if it is empty.
+	Secret string
+	// MaxRetries is the amount of retries after a failed delivery
+	MaxRetries int
+	// InitialBackoff is the wait time before the first retry. It is doubled for every further retry.
+	InitialBackoff time.Duration
+	Client         *http.Client
+}
+
+func NewWebhook(url string) *Webhook {
+	return &Webhook{
+		URL:            url,
+		MaxRetries:     defaultMaxRetries,
+		InitialBackoff: defaultInitialBackoff,
+		Client: &http.Client{
+			Timeout: webhookTimeout,
+		},
+	}
+}
+
+func (w *Webhook) Accepts(interaction *ohren.Interaction) bool {
+	return w.Filter.Matches(interaction)
+}
+
+func (w *Webhook) Notify(interaction *ohren.Interaction) error {
+	body, err := json.Marshal(interaction)
+	if err != nil {
+		return err
+	}
+	backoff := w.InitialBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= w.MaxRetries {
+			return fmt.Errorf("webhook %s failed after %d attempts: %s", w.URL, attempt+1, err)
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// post sends the body once and returns whether a failed request should be retried.
+func (w *Webhook) post(body []byte) (retry bool, err error) {
+	request, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("User-Agent", "ohren")
+	if w.Secret != "" {
+		request.Header.Set(SignatureHeader, "sha256="+Sign(w.Secret, body))
+	}
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return true, err
+	}
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(response.Body, 64*1024))
+	_ = response.Body.Close()
+	switch {
+	case response.StatusCode >= 200 && response.StatusCode < 300:
+		return false, nil
+	case response.StatusCode == http.StatusTooManyRequests || response.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", response.Status)
+	default:
+		return false, fmt.Errorf("status %s", response.Status)
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
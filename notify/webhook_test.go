@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"encoding/json"
+	"github.com/coffeemakr/ohren"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name     string
+		secret   string
+		statuses []int
+		attempts int
+		failed   bool
+	}{
+		{name: "delivered", statuses: []int{200}, attempts: 1},
+		{name: "signed", secret: "secret", statuses: []int{204}, attempts: 1},
+		{name: "retried on 5xx", statuses: []int{500, 503, 200}, attempts: 3},
+		{name: "retried on 429", statuses: []int{429, 200}, attempts: 2},
+		{name: "not retried on 4xx", statuses: []int{404}, attempts: 1, failed: true},
+		{name: "retries exhausted", statuses: []int{500, 500, 500}, attempts: 3, failed: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mutex sync.Mutex
+			var bodies [][]byte
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := ioutil.ReadAll(r.Body)
+				mutex.Lock()
+				attempt := len(bodies)
+				bodies = append(bodies, body)
+				mutex.Unlock()
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("%s request with content type %q", r.Method, r.Header.Get("Content-Type"))
+				}
+				signature := r.Header.Get(SignatureHeader)
+				if test.secret == "" && signature != "" {
+					t.Errorf("unexpected signature %q", signature)
+				}
+				if test.secret != "" && signature != "sha256="+Sign(test.secret, body) {
+					t.Errorf("signature %q does not match the body", signature)
+				}
+				status := http.StatusInternalServerError
+				if attempt < len(test.statuses) {
+					status = test.statuses[attempt]
+				}
+				w.WriteHeader(status)
+			}))
+			defer server.Close()
+
+			webhook := NewWebhook(server.URL)
+			webhook.Secret = test.secret
+			webhook.MaxRetries = 2
+			webhook.InitialBackoff = time.Millisecond
+			interaction := &ohren.Interaction{ID: 7, Type: string(ohren.RequestTypeDNS), Tokens: []string{"abc"}}
+			err := webhook.Notify(interaction)
+			if failed := err != nil; failed != test.failed {
+				t.Errorf("Notify returned %v", err)
+			}
+			if len(bodies) != test.attempts {
+				t.Fatalf("%d attempts, want %d", len(bodies), test.attempts)
+			}
+			delivered := new(ohren.Interaction)
+			if err := json.Unmarshal(bodies[0], delivered); err != nil {
+				t.Fatal(err)
+			}
+			if delivered.ID != 7 || delivered.Type != interaction.Type || strings.Join(delivered.Tokens, ",") != "abc" {
+				t.Errorf("delivered %+v", delivered)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	// RFC 4231 test case 2
+	signature := Sign("Jefe", []byte("what do ya want for nothing?"))
+	if signature != "5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843" {
+		t.Errorf("signature %s", signature)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -23,9 +24,34 @@ type RequestDetails interface {
 	Hosts() []string
 }
 
+// RawDetails is implemented by request details which can describe the request
+// and the response separately.
+type RawDetails interface {
+	RawRequest() string
+	RawResponse() string
+}
+
+// ProtocolDetails is implemented by request details which distinguish protocols
+// of the same request type.
+type ProtocolDetails interface {
+	RequestProtocol() string
+}
+
 type HttpRequestDetails struct {
+	// Protocol is the label returned by HttpProtocol
+	Protocol string
 	Request  *http.Request
 	Response *http.Response
+	// Body is the captured request body
+	Body *CapturedBody
+	// ResponseBody is the body sent in the response
+	ResponseBody *CapturedBody
+	// Notes describe how the response was chosen (e.g. the matched rule)
+	Notes []string
+	// IssuedTokens are correlation tokens handed out in the response
+	IssuedTokens []string
+	// Dropped is true if the connection was closed without sending a response
+	Dropped bool
 }
 
 func (d HttpRequestDetails) Type() RequestType {
@@ -36,17 +62,68 @@ func (d HttpRequestDetails) Hosts() []string {
 	return []string{d.Request.Host}
 }
 
-func (d HttpRequestDetails) Describe() string {
+func (d HttpRequestDetails) SearchableContent() []string {
+	content := []string{d.Request.RequestURI}
+	if d.Body != nil {
+		content = append(content, string(d.Body.Data))
+	}
+	return append(content, d.IssuedTokens...)
+}
+
+func (d HttpRequestDetails) RequestProtocol() string {
+	return d.Protocol
+}
+
+func (d HttpRequestDetails) RequestBody() *CapturedBody {
+	return d.Body
+}
+
+// RawRequest describes the request as it was received. The body is rendered
+// by CapturedBody.String, because it was already consumed.
+func (d HttpRequestDetails) RawRequest() string {
+	buffer := new(bytes.Buffer)
+	fmt.Fprintf(buffer, "%s %s %s\r\n", d.Request.Method, d.Request.RequestURI, d.Request.Proto)
+	fmt.Fprintf(buffer, "Host: %s\r\n", d.Request.Host)
+	if len(d.Request.TransferEncoding) > 0 {
+		fmt.Fprintf(buffer, "Transfer-Encoding: %s\r\n", strings.Join(d.Request.TransferEncoding, ", "))
+	}
+	d.Request.Header.Write(buffer)
+	buffer.WriteString("\r\n")
+	buffer.WriteString(d.Body.String())
+	return buffer.String()
+}
+
+func (d HttpRequestDetails) RawResponse() string {
+	if d.Response == nil || d.Dropped {
+		return ""
+	}
 	buffer := new(bytes.Buffer)
-	buffer.WriteString("> Request:\n")
-	d.Request.Write(buffer)
-	buffer.WriteString("\n> Response:\n")
-	d.Response.Write(buffer)
+	fmt.Fprintf(buffer, "%s %d %s\r\n", d.Response.Proto, d.Response.StatusCode, http.StatusText(d.Response.StatusCode))
+	d.Response.Header.Write(buffer)
+	if d.Response.ContentLength >= 0 && d.Response.Header.Get("Content-Length") == "" {
+		fmt.Fprintf(buffer, "Content-Length: %d\r\n", d.Response.ContentLength)
+	}
+	buffer.WriteString("\r\n")
+	buffer.WriteString(d.ResponseBody.String())
 	return buffer.String()
 }
 
+func (d HttpRequestDetails) Describe() string {
+	response := d.RawResponse()
+	if d.Dropped {
+		response = "no response sent, the connection was dropped\n"
+	}
+	description := "> Request:\n" + d.RawRequest() + "\n> Response:\n" + response
+	if len(d.Notes) > 0 {
+		description += "\n> Notes:\n" + strings.Join(d.Notes, "\n")
+	}
+	return description
+}
+
 type DnsRequestDetails struct {
 	RequestedHosts []string
+	// RequestedTypes are the asked record types, e.g. "MX"
+	RequestedTypes []string
 	Request        *dns.Msg
 	Response       *dns.Msg
 }
@@ -55,8 +132,16 @@ func (d DnsRequestDetails) Type() RequestType {
 	return RequestTypeDNS
 }
 
+func (d DnsRequestDetails) RawRequest() string {
+	return d.Request.String()
+}
+
+func (d DnsRequestDetails) RawResponse() string {
+	return d.Response.String()
+}
+
 func (d DnsRequestDetails) Describe() string {
-	return fmt.Sprintf("> Request: \n%s\n\n>Response:\n%s", d.Request.String(), d.Response.String())
+	return fmt.Sprintf("> Request: \n%s\n\n>Response:\n%s", d.RawRequest(), d.RawResponse())
 }
 
 func (d DnsRequestDetails) Hosts() []string {
@@ -64,6 +149,8 @@ func (d DnsRequestDetails) Hosts() []string {
 }
 
 type RecordedConnection struct {
+	// ID is assigned when the record is stored
+	ID            uint64
 	RemotePort    int
 	RemoteAddress string
 	LocalPort     int
@@ -72,6 +159,14 @@ type RecordedConnection struct {
 	EndTime       time.Time
 	Details       RequestDetails
 	Error         error
+	// Tokens contains the correlation tokens found in the request
+	Tokens []string
+	// Owner is the user owning the tokens or the namespace of the request
+	Owner string
+	// ConnectionID is shared by all records of requests on the same connection
+	ConnectionID string
+	// RequestNumber is the position of the request on the connection starting at 1
+	RequestNumber int
 }
 
 func (c *RecordedConnection) SetLocalAddress(addr net.Addr) {
@@ -109,3 +204,16 @@ func (c *RecordedConnection) SetRemoteAddress(addr net.Addr) {
 }
 
 type Record *RecordedConnection
+
+// Distribute passes every record from in to all outs. The outs are closed when
+// in was closed.
+func Distribute(in chan Record, outs ...chan Record) {
+	for record := range in {
+		for _, out := range outs {
+			out <- record
+		}
+	}
+	for _, out := range outs {
+		close(out)
+	}
+}
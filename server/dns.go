@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coffeemakr/ohren"
+	"github.com/miekg/dns"
+	"log"
+	"net"
+	"strings"
+)
+
+// DnsRecordConfig is a static DNS record.
+type DnsRecordConfig struct {
+	// Name is relative to the hostname unless it ends with a dot. "@" is the
+	// hostname itself and "*" matches all subdomains without own records.
+	Name string `yaml:"name"`
+	// Type is e.g. "TXT", "MX", "CNAME", "SRV" or "CAA"
+	Type string `yaml:"type"`
+	// Value is the data in zone file format, e.g. "10 mail" for MX records
+	Value string `yaml:"value"`
+	// TTL defaults to 3600
+	TTL uint32 `yaml:"ttl"`
+}
+
+// DnsRebindingConfig configures names which resolve alternately or sequentially
+// to the public ip and a target ip.
+type DnsRebindingConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Label precedes the hostname, defaults to "rebind"
+	Label string `yaml:"label"`
+	// Target is the IPv4 address used if the name contains no second address,
+	// e.g. "127.0.0.1"
+	Target string `yaml:"target"`
+	// Sequential answers the first address First times (default 1) and the
+	// target afterwards instead of alternating
+	Sequential bool `yaml:"sequential"`
+	First      int  `yaml:"first"`
+}
+
+func getDnsRecords(config *ServerConfig) ([]dns.RR, error) {
+	origin := dns.Fqdn(config.Hostname)
+	var records []dns.RR
+	for _, recordConfig := range config.Dns.Records {
+		record, err := getDnsRecord(&recordConfig, origin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns record %s %s: %s", recordConfig.Name, recordConfig.Type, err)
+		}
+		records = append(records, record)
+	}
+	for _, path := range config.Dns.ZoneFiles {
+		zoneRecords, err := ohren.ReadZoneFile(path, origin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zone file: %s", err)
+		}
+		records = append(records, zoneRecords...)
+	}
+	return records, nil
+}
+
+func getDnsRecord(config *DnsRecordConfig, origin string) (dns.RR, error) {
+	if _, ok := dns.StringToType[strings.ToUpper(config.Type)]; !ok {
+		return nil, fmt.Errorf("unknown type: %s", config.Type)
+	}
+	name := config.Name
+	if name == "" {
+		name = "@"
+	}
+	ttl := config.TTL
+	if ttl == 0 {
+		ttl = 3600
+	}
+	parser := dns.NewZoneParser(strings.NewReader(fmt.Sprintf("%s %d IN %s %s", name, ttl, config.Type, config.Value)), origin, "")
+	record, ok := parser.Next()
+	if err := parser.Err(); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("empty record")
+	}
+	return record, nil
+}
+
+func getDnsRebinding(config *DnsRebindingConfig) *ohren.DnsRebinding {
+	if !config.Enabled {
+		return nil
+	}
+	rebinding := &ohren.DnsRebinding{
+		Label:      config.Label,
+		Sequential: config.Sequential,
+		First:      config.First,
+	}
+	if config.Target != "" {
+		target := net.ParseIP(config.Target)
+		if target == nil {
+			log.Fatalf("invalid rebinding target: %s\n", config.Target)
+		}
+		rebinding.Target = target.To4()
+		if rebinding.Target == nil {
+			log.Fatalf("invalid rebinding target: %s is no IPv4 address, rebinding only answers A queries\n", config.Target)
+		}
+	}
+	return rebinding
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"github.com/miekg/dns"
+	"testing"
+)
+
+func TestGetDnsRecordsZoneFiles(t *testing.T) {
+	config := &ServerConfig{Hostname: "d.example"}
+	config.Dns.Records = []DnsRecordConfig{{Name: "@", Type: "TXT", Value: "\"configured\""}}
+	config.Dns.ZoneFiles = []string{"example.zone"}
+	records, err := getDnsRecords(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the configured records are followed by the records of the zone files
+	if len(records) != 10 || records[0].Header().Rrtype != dns.TypeTXT {
+		t.Fatalf("records %v", records)
+	}
+	names := make(map[string]uint16)
+	for _, record := range records[1:] {
+		names[record.Header().Name] = record.Header().Rrtype
+		if record.Header().Ttl != 300 {
+			t.Errorf("ttl of %s", record)
+		}
+	}
+	if names["mail.d.example."] != dns.TypeA || names["www.d.example."] != dns.TypeCNAME || names["_acme-challenge.d.example."] != dns.TypeTXT {
+		t.Errorf("zone records %v", records[1:])
+	}
+
+	config.Dns.ZoneFiles = []string{"missing.zone"}
+	if _, err := getDnsRecords(config); err == nil {
+		t.Error("missing zone file accepted")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/coffeemakr/ohren"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// HttpMatchConfig selects requests. Empty fields match all requests.
+type HttpMatchConfig struct {
+	// Host is a glob pattern like "*.example.com"
+	Host string `yaml:"host"`
+	// Path is a glob pattern like "/js/*.js"
+	Path      string   `yaml:"path"`
+	PathRegex string   `yaml:"path_regex"`
+	Methods   []string `yaml:"methods"`
+	// Headers maps header names to regular expressions
+	Headers map[string]string `yaml:"headers"`
+	// Token must be contained in the request, "*" matches any generated token
+	Token string `yaml:"token"`
+}
+
+type HttpResponseConfig struct {
+	// Status defaults to 200
+	Status      int               `yaml:"status"`
+	Headers     map[string]string `yaml:"headers"`
+	ContentType string            `yaml:"content_type"`
+	Body        string            `yaml:"body"`
+	// BodyFile is read instead of Body if set
+	BodyFile string `yaml:"body_file"`
+	// Template renders the body as Go template with the fields of ohren.HttpTemplateData,
+	// e.g. {{.Token}}, {{.Host}}, {{.Query.Get "id"}} or {{.Header.Get "User-Agent"}}
+	Template bool `yaml:"template"`
+	// Redirect is sent instead of the other fields if set
+	Redirect *HttpRedirectConfig `yaml:"redirect"`
+	// Directory serves the files of the directory instead of the other fields if set
+	Directory *HttpDirectoryConfig `yaml:"directory"`
+	// Delay is waited before the response is sent
+	Delay time.Duration `yaml:"delay"`
+	// Trickle is waited before each byte of the body is sent
+	Trickle time.Duration `yaml:"trickle"`
+	// Hang holds the request without a response and closes the connection afterwards
+	Hang time.Duration `yaml:"hang"`
+	// CloudMetadata emulates the AWS, GCP and Azure metadata services instead of
+	// the other fields. The fake credentials contain the correlation token.
+	CloudMetadata bool `yaml:"cloud_metadata"`
+}
+
+type HttpRedirectConfig struct {
+	// Status is one of 301, 302, 303, 307 or 308 and defaults to 302
+	Status int `yaml:"status"`
+	// Location is the target, any scheme is allowed
+	Location string `yaml:"location"`
+	// QueryParameter overrides the location if it is present in the request
+	QueryParameter string `yaml:"query_parameter"`
+}
+
+type HttpDirectoryConfig struct {
+	Path string `yaml:"path"`
+	// StripPrefix is removed from the request path before the file is looked up
+	StripPrefix string `yaml:"strip_prefix"`
+}
+
+// GrpcConfig configures the response to gRPC calls over h2 and h2c.
+type GrpcConfig struct {
+	// Status is the returned gRPC status code, defaults to 12 (unimplemented)
+	Status  *int   `yaml:"status"`
+	Message string `yaml:"message"`
+	// DescriptorSet is a file written by "protoc --include_imports --descriptor_set_out"
+	// which is used to decode the messages
+	DescriptorSet string `yaml:"descriptor_set"`
+}
+
+// HttpWebsocketConfig configures the websocket connections accepted by the http responders.
+// Upgrades are only accepted if no rule matches the request.
+type HttpWebsocketConfig struct {
+	// Duration limits the time a connection is kept open, defaults to 30s
+	Duration time.Duration `yaml:"duration"`
+	// Echo sends every received message back
+	Echo bool `yaml:"echo"`
+	// Messages are sent after the handshake
+	Messages []string `yaml:"messages"`
+}
+
+// HttpRuleConfig configures the response to requests matching the rule.
+// The first matching rule is used.
+type HttpRuleConfig struct {
+	Name     string             `yaml:"name"`
+	Match    HttpMatchConfig    `yaml:"match"`
+	Response HttpResponseConfig `yaml:"response"`
+}
+
+// getHttpHandler returns the handler of all HTTP requests. gRPC calls are
+// answered first, other requests by the first matching rule. Websocket upgrades
+// are accepted if no rule matches and the default page is served otherwise.
+func getHttpHandler(config *ServerConfig, tokens *ohren.TokenRegistry) (http.Handler, error) {
+	websocketHandler := &ohren.WebsocketHandler{
+		Duration:       config.Http.Websocket.Duration,
+		Echo:           config.Http.Websocket.Echo,
+		Messages:       config.Http.Websocket.Messages,
+		MaxMessageSize: config.Http.MaxBodySize,
+		Fallback:       ohren.DefaultHtmlResponder.Handler(),
+	}
+	handler := &ohren.HttpRules{
+		Default: websocketHandler,
+		Tokens:  tokens,
+	}
+	for i, ruleConfig := range config.Http.Rules {
+		name := ruleConfig.Name
+		if name == "" {
+			name = strconv.Itoa(i + 1)
+		}
+		match, err := getHttpMatcher(&ruleConfig.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid match of http rule %s: %s", name, err)
+		}
+		response, err := getHttpResponse(&ruleConfig.Response, tokens)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response of http rule %s: %s", name, err)
+		}
+		handler.Rules = append(handler.Rules, &ohren.HttpRule{
+			Name:    name,
+			Match:   *match,
+			Handler: response,
+		})
+	}
+	return getGrpcHandler(&config.Http.Grpc, config.Http.MaxBodySize, handler)
+}
+
+func getGrpcHandler(config *GrpcConfig, maxMessageSize int64, fallback http.Handler) (*ohren.GrpcHandler, error) {
+	handler := &ohren.GrpcHandler{
+		Status:         ohren.GrpcStatusUnimplemented,
+		Message:        config.Message,
+		MaxMessageSize: maxMessageSize,
+		Fallback:       fallback,
+	}
+	if config.Status != nil {
+		handler.Status = *config.Status
+	}
+	if config.DescriptorSet != "" {
+		descriptors, err := ohren.ReadDescriptorSet(config.DescriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid grpc descriptor_set: %s", err)
+		}
+		handler.Descriptors = descriptors
+	}
+	return handler, nil
+}
+
+func getHttpMatcher(config *HttpMatchConfig) (*ohren.HttpMatcher, error) {
+	matcher := &ohren.HttpMatcher{
+		Host:    config.Host,
+		Path:    config.Path,
+		Methods: config.Methods,
+		Token:   config.Token,
+	}
+	var err error
+	if config.PathRegex != "" {
+		matcher.PathRegex, err = regexp.Compile(config.PathRegex)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(config.Headers) > 0 {
+		matcher.Headers = make(map[string]*regexp.Regexp, len(config.Headers))
+		for name, expression := range config.Headers {
+			matcher.Headers[name], err = regexp.Compile(expression)
+			if err != nil {
+				return nil, fmt.Errorf("header %s: %s", name, err)
+			}
+		}
+	}
+	return matcher, nil
+}
+
+func getHttpResponse(config *HttpResponseConfig, tokens *ohren.TokenRegistry) (http.Handler, error) {
+	handler, err := getHttpResponseHandler(config, tokens)
+	if err != nil {
+		return nil, err
+	}
+	if config.Delay < 0 || config.Trickle < 0 || config.Hang < 0 {
+		return nil, errors.New("delay, trickle and hang must not be negative")
+	}
+	if config.Delay == 0 && config.Trickle == 0 && config.Hang == 0 {
+		return handler, nil
+	}
+	return &ohren.HttpDelay{
+		Handler: handler,
+		Delay:   config.Delay,
+		Trickle: config.Trickle,
+		Hang:    config.Hang,
+	}, nil
+}
+
+func getHttpResponseHandler(config *HttpResponseConfig, tokens *ohren.TokenRegistry) (http.Handler, error) {
+	if config.Redirect != nil {
+		return getHttpRedirect(config.Redirect)
+	}
+	if config.Directory != nil {
+		return getHttpDirectory(config.Directory)
+	}
+	if config.CloudMetadata {
+		return &ohren.CloudMetadata{Tokens: tokens}, nil
+	}
+	if config.Status != 0 && (config.Status < 100 || config.Status > 999) {
+		return nil, fmt.Errorf("invalid status: %d", config.Status)
+	}
+	header := make(http.Header, len(config.Headers))
+	for name, value := range config.Headers {
+		header.Set(name, value)
+	}
+	body := []byte(config.Body)
+	if config.BodyFile != "" {
+		var err error
+		body, err = ioutil.ReadFile(config.BodyFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.Template {
+		bodyTemplate, err := template.New("body").Parse(string(body))
+		if err != nil {
+			return nil, err
+		}
+		return &ohren.HttpTemplate{
+			StatusCode:  config.Status,
+			Header:      header,
+			ContentType: config.ContentType,
+			Template:    bodyTemplate,
+			Tokens:      tokens,
+		}, nil
+	}
+	return &ohren.HttpResponse{
+		StatusCode:  config.Status,
+		Header:      header,
+		ContentType: config.ContentType,
+		Body:        body,
+	}, nil
+}
+
+func getHttpRedirect(config *HttpRedirectConfig) (*ohren.HttpRedirect, error) {
+	if config.Status != 0 && !ohren.IsRedirectStatus(config.Status) {
+		return nil, fmt.Errorf("invalid redirect status: %d", config.Status)
+	}
+	if config.Location == "" && config.QueryParameter == "" {
+		return nil, errors.New("redirect requires location or query_parameter")
+	}
+	return &ohren.HttpRedirect{
+		StatusCode:     config.Status,
+		Location:       config.Location,
+		QueryParameter: config.QueryParameter,
+	}, nil
+}
+
+func getHttpDirectory(config *HttpDirectoryConfig) (*ohren.HttpDirectory, error) {
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("not a directory: %s", config.Path)
+	}
+	return &ohren.HttpDirectory{
+		Root:        config.Path,
+		StripPrefix: config.StripPrefix,
+	}, nil
+}
+
+// getHttp3Port returns the port of the first http3 responder or 0.
+func getHttp3Port(config *ServerConfig) int {
+	for _, responder := range config.Responders {
+		if responder.Type == ResponderTypeHttp3 {
+			return responder.ListenPort
+		}
+	}
+	return 0
+}
+
+// advertiseHttp3 announces HTTP/3 on the port in the Alt-Svc header of HTTP/1 and HTTP/2 responses.
+func advertiseHttp3(handler http.Handler, port int) http.Handler {
+	altSvc := fmt.Sprintf(`h3=":%d"; ma=86400`, port)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor < 3 {
+			w.Header().Set("Alt-Svc", altSvc)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdvertiseHttp3(t *testing.T) {
+	handler := advertiseHttp3(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), 8443)
+	tests := []struct {
+		name       string
+		protoMajor int
+		altSvc     string
+	}{
+		{name: "HTTP/1.1", protoMajor: 1, altSvc: `h3=":8443"; ma=86400`},
+		{name: "HTTP/2", protoMajor: 2, altSvc: `h3=":8443"; ma=86400`},
+		{name: "HTTP/3", protoMajor: 3, altSvc: ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.ProtoMajor = test.protoMajor
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+			if altSvc := w.Header().Get("Alt-Svc"); altSvc != test.altSvc {
+				t.Errorf("Alt-Svc %q, want %q", altSvc, test.altSvc)
+			}
+			if w.Code != http.StatusNoContent {
+				t.Errorf("status %d", w.Code)
+			}
+		})
+	}
+}
+
+func TestGetHttp3Port(t *testing.T) {
+	config := &ServerConfig{Responders: []ResponderConfig{
+		{Type: ResponderTypeHttp, ListenPort: 8080},
+		{Type: ResponderTypeHttp3, ListenPort: 8443},
+	}}
+	if port := getHttp3Port(config); port != 8443 {
+		t.Errorf("port %d, want 8443", port)
+	}
+	if port := getHttp3Port(&ServerConfig{}); port != 0 {
+		t.Errorf("port %d without http3 responder", port)
+	}
+}
+
+func TestGetHttpRedirect(t *testing.T) {
+	tests := []struct {
+		name   string
+		config HttpRedirectConfig
+		valid  bool
+	}{
+		{name: "location", config: HttpRedirectConfig{Location: "/a"}, valid: true},
+		{name: "query parameter", config: HttpRedirectConfig{Status: 308, QueryParameter: "to"}, valid: true},
+		{name: "no target", config: HttpRedirectConfig{Status: 301}, valid: false},
+		{name: "no redirect status", config: HttpRedirectConfig{Status: 200, Location: "/a"}, valid: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := getHttpRedirect(&test.config); (err == nil) != test.valid {
+				t.Errorf("error %v", err)
+			}
+		})
+	}
+}
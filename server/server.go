@@ -6,13 +6,21 @@ import (
 	"flag"
 	"fmt"
 	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/api"
+	"github.com/coffeemakr/ohren/auth"
+	"github.com/coffeemakr/ohren/interactsh"
+	"github.com/coffeemakr/ohren/notify"
 	"github.com/coffeemakr/ohren/websocket"
+	"github.com/miekg/dns"
 	"gopkg.in/yaml.v3"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 )
@@ -22,6 +30,8 @@ type ResponderType string
 const (
 	ResponderTypeDns  ResponderType = "dns"
 	ResponderTypeHttp ResponderType = "http"
+	// ResponderTypeHttp3 listens for QUIC on the UDP port and requires the tls certificates
+	ResponderTypeHttp3 ResponderType = "http3"
 )
 
 type ResponderConfig struct {
@@ -34,20 +44,128 @@ type WebsocketConfig struct {
 	ListenHost string `yaml:"host"`
 }
 
+type StoreConfig struct {
+	// Path of the file to store the interactions in. If empty, the interactions are only kept in memory.
+	Path string `yaml:"path"`
+	// TokensPath is the file to store the generated tokens in. Defaults to the
+	// Path with the extension ".tokens" if the Path is set.
+	TokensPath string `yaml:"tokens_path"`
+}
+
+type InteractshConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CorrelationIDLength must match the length used by the clients
+	CorrelationIDLength int `yaml:"correlation_id_length"`
+}
+
+type AuthConfig struct {
+	// APIKeys are accepted as bearer token, X-API-Key header or api_key query parameter
+	APIKeys   []string `yaml:"api_keys"`
+	BasicAuth struct {
+		Username string `yaml:"username"`
+		Password string `yaml:"password"`
+	} `yaml:"basic_auth"`
+	// AllowedOrigins may access the admin server in addition to the same origin
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// UserConfig configures a user which can only access its own interactions.
+type UserConfig struct {
+	Name string `yaml:"name"`
+	// Password for basic authentication with the name as username
+	Password string   `yaml:"password"`
+	APIKeys  []string `yaml:"api_keys"`
+	// Namespace is the subdomain below the hostname owned by the user. Defaults to the name.
+	// Interactsh clients of the user must use it as server domain.
+	Namespace string `yaml:"namespace"`
+}
+
+type WebhookConfig struct {
+	URL string `yaml:"url"`
+	// Secret signs the body in the X-Ohren-Signature header
+	Secret string `yaml:"secret"`
+	// MaxRetries defaults to 3
+	MaxRetries int `yaml:"max_retries"`
+	// InitialBackoff defaults to 1s and doubles with every retry
+	InitialBackoff time.Duration `yaml:"initial_backoff"`
+	Filter         notify.Filter `yaml:"filter"`
+}
+
+type EmailConfig struct {
+	// SMTP is the address (host:port) of the relay
+	SMTP     string   `yaml:"smtp"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+	// BatchInterval is the time to collect interactions before sending a mail
+	BatchInterval time.Duration `yaml:"batch_interval"`
+	// MinInterval is the minimum time between two mails, defaults to 1m
+	MinInterval time.Duration `yaml:"min_interval"`
+	// MaxInteractions per mail, defaults to 50
+	MaxInteractions int           `yaml:"max_interactions"`
+	Filter          notify.Filter `yaml:"filter"`
+}
+
 type ServerConfig struct {
 	Hostname    string   `yaml:"hostname"`
 	ListenHosts []string `yaml:"listen_hosts"`
 	Dns         struct {
 		PublicIPs []string `yaml:"public_ips"`
+		// Records are answered before the public ips
+		Records []DnsRecordConfig `yaml:"records"`
+		// ZoneFiles are RFC 1035 zone files, relative names are below the hostname
+		// unless the file sets $ORIGIN
+		ZoneFiles []string `yaml:"zone_files"`
+		// Foreign is the response to names outside of the hostname and the zone
+		// files: "refuse" (default), "nxdomain" or "drop"
+		Foreign ohren.DnsForeignPolicy `yaml:"foreign"`
+		// RecordForeign records queries for names outside of the zones
+		RecordForeign bool `yaml:"record_foreign"`
+		// Rebinding answers names like a.<ip1>.b.<ip2>.rebind.<hostname>
+		Rebinding DnsRebindingConfig `yaml:"rebinding"`
 	} `yaml:"dns"`
 	Http struct {
 		Certificate string `yaml:"tls_certificate"`
 		Key         string `json:"tls_key"`
+		// MaxBodySize is the maximum amount of bytes captured of request bodies
+		MaxBodySize int64 `yaml:"max_body_size"`
+		// MaxHeaderBytes limits the request line and headers of HTTP/1 requests,
+		// defaults to 1 MiB
+		MaxHeaderBytes int `yaml:"max_header_bytes"`
+		// MaxConnections limits the connections served at the same time per
+		// listener, defaults to 1000
+		MaxConnections int `yaml:"max_connections"`
+		// Rules select the response, the default page is served if no rule matches
+		Rules []HttpRuleConfig `yaml:"rules"`
+		// Grpc configures the response to all gRPC calls
+		Grpc GrpcConfig `yaml:"grpc"`
+		// Websocket configures the websocket connections upgraded from captured requests
+		Websocket HttpWebsocketConfig `yaml:"websocket"`
+		// KeepAlive limits the requests answered on one connection
+		KeepAlive struct {
+			// MaxRequests defaults to 100
+			MaxRequests int `yaml:"max_requests"`
+			// IdleTimeout defaults to 5s
+			IdleTimeout time.Duration `yaml:"idle_timeout"`
+		} `yaml:"keep_alive"`
 	} `yaml:"http"`
 
 	Responders []ResponderConfig `yaml:"responders"`
 
 	Websocket WebsocketConfig `yaml:"websocket"`
+
+	Store StoreConfig `yaml:"store"`
+
+	Interactsh InteractshConfig `yaml:"interactsh"`
+
+	Auth AuthConfig `yaml:"auth"`
+
+	Users []UserConfig `yaml:"users"`
+
+	Webhooks []WebhookConfig `yaml:"webhooks"`
+
+	Emails []EmailConfig `yaml:"emails"`
 }
 
 var defaultConfig = &ServerConfig{
@@ -91,14 +209,56 @@ func checkConfig(config *ServerConfig) (warnings []string, err error) {
 		config.Websocket.ListenPort = defaultConfig.Websocket.ListenPort
 	}
 
+	if len(config.Auth.APIKeys) == 0 && config.Auth.BasicAuth.Password == "" && len(config.Users) == 0 {
+		warnings = append(warnings, "No api_keys, basic_auth or users configured, the admin server is not protected")
+	}
+
+	userNames := make(map[string]bool)
+	namespaces := make(map[string]bool)
+	for i := range config.Users {
+		user := &config.Users[i]
+		if user.Name == "" || user.Name == auth.AdminName {
+			err = fmt.Errorf("invalid user name: %q", user.Name)
+			return
+		}
+		if user.Name == config.Auth.BasicAuth.Username {
+			err = fmt.Errorf("user name is used for basic_auth: %s", user.Name)
+			return
+		}
+		if userNames[user.Name] {
+			err = fmt.Errorf("duplicate user: %s", user.Name)
+			return
+		}
+		userNames[user.Name] = true
+		if user.Namespace == "" {
+			user.Namespace = user.Name
+		}
+		user.Namespace = ohren.NormalizeHost(user.Namespace)
+		if _, ok := dns.IsDomainName(user.Namespace); !ok {
+			err = fmt.Errorf("invalid namespace of user %s: %s", user.Name, user.Namespace)
+			return
+		}
+		if namespaces[user.Namespace] {
+			err = fmt.Errorf("duplicate namespace: %s", user.Namespace)
+			return
+		}
+		namespaces[user.Namespace] = true
+		if user.Password == "" && len(user.APIKeys) == 0 {
+			warnings = append(warnings, fmt.Sprintf("User %s has no password or api_keys", user.Name))
+		}
+	}
+
 	var hasDnsResponder bool
 	var hasHttpResponder bool
+	var hasHttp3Responder bool
 	for _, responder := range config.Responders {
 		switch responder.Type {
 		case ResponderTypeDns:
 			hasDnsResponder = true
 		case ResponderTypeHttp:
 			hasHttpResponder = true
+		case ResponderTypeHttp3:
+			hasHttp3Responder = true
 		}
 	}
 	if hasHttpResponder {
@@ -106,7 +266,19 @@ func checkConfig(config *ServerConfig) (warnings []string, err error) {
 			warnings = append(warnings, "No tls certificates provided, unable to use TLS")
 		}
 	}
+	if hasHttp3Responder {
+		if config.Http.Key == "" || config.Http.Certificate == "" {
+			err = errors.New("http3 responder requires tls certificates")
+			return
+		}
+	}
 	if hasDnsResponder {
+		switch config.Dns.Foreign {
+		case "", ohren.DnsForeignRefuse, ohren.DnsForeignNXDomain, ohren.DnsForeignDrop:
+		default:
+			err = fmt.Errorf("invalid dns foreign policy: %s", config.Dns.Foreign)
+			return
+		}
 		if len(config.Dns.PublicIPs) == 0 {
 			var publicIps []net.IP
 			warnings = append(warnings, "No public_ips configured, detecting automatically")
@@ -120,6 +292,22 @@ func checkConfig(config *ServerConfig) (warnings []string, err error) {
 			}
 		}
 	}
+	for _, webhook := range config.Webhooks {
+		if _, parseErr := url.ParseRequestURI(webhook.URL); parseErr != nil {
+			err = fmt.Errorf("invalid webhook url: %s", webhook.URL)
+			return
+		}
+	}
+	for _, email := range config.Emails {
+		if _, _, splitErr := net.SplitHostPort(email.SMTP); splitErr != nil {
+			err = fmt.Errorf("invalid smtp address: %s", email.SMTP)
+			return
+		}
+		if email.From == "" || len(email.To) == 0 {
+			err = errors.New("email requires from and to")
+			return
+		}
+	}
 	for _, responder := range config.Responders {
 		if responder.ListenPort == 0 {
 			err = errors.New("responder has no port configured")
@@ -152,17 +340,64 @@ func main() {
 
 	var wg sync.WaitGroup
 
+	store, err := getStore(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
 	recordChannel := make(chan ohren.Record)
+	taggedChannel := make(chan ohren.Record)
+	storedChannel := make(chan ohren.Record)
+
+	tokens := ohren.NewTokenRegistry(config.Hostname)
+	for _, user := range config.Users {
+		tokens.SetNamespace(user.Name, user.Namespace)
+	}
+	if path := getTokensPath(config); path != "" {
+		if err := tokens.Open(path); err != nil {
+			log.Fatalf("failed to open tokens: %s", err)
+		}
+	}
+	go tokens.Tag(recordChannel, taggedChannel)
+	go ohren.StoreRecords(store, taggedChannel, storedChannel)
+
+	websocketChannel := make(chan ohren.Record)
+	consumers := []chan ohren.Record{websocketChannel}
 
-	ws := websocket.NewWebsocketHandler(recordChannel)
+	authenticator := getAuthenticator(config)
+
+	ws := websocket.NewWebsocketHandler(websocketChannel, tokens, store)
+	ws.CheckOrigin = authenticator.CheckOrigin
 	go ws.RunBroadcast()
 
 	mux := http.NewServeMux()
 	mux.Handle("/ws", ws)
+	mux.Handle("/api/", http.StripPrefix("/api", api.NewHandler(store)))
+
+	if config.Interactsh.Enabled {
+		interactshServer := interactsh.NewServer(config.Hostname)
+		interactshServer.Tokens = tokens
+		if config.Interactsh.CorrelationIDLength != 0 {
+			interactshServer.CorrelationIDLength = config.Interactsh.CorrelationIDLength
+		}
+		interactshChannel := make(chan ohren.Record)
+		consumers = append(consumers, interactshChannel)
+		go interactshServer.Record(interactshChannel)
+		mux.Handle("/interactsh/", http.StripPrefix("/interactsh", interactshServer))
+	}
+
+	notifiers := getNotifiers(config)
+	if len(notifiers) > 0 {
+		notifyChannel := make(chan ohren.Record)
+		consumers = append(consumers, notifyChannel)
+		go notify.Run(notifyChannel, notifiers)
+	}
+
+	go ohren.Distribute(storedChannel, consumers...)
 	mux.Handle("/", http.FileServer(http.Dir("./static")))
 
 	adminServer := &http.Server{
-		Handler: mux,
+		Handler: authenticator.Require(mux),
 		Addr:    fmt.Sprintf("%s:%d", config.Websocket.ListenHost, config.Websocket.ListenPort),
 	}
 	go func() {
@@ -175,7 +410,15 @@ func main() {
 	var handlers []ohren.Listener
 
 	dnsResponder := getDnsResponder(config)
-	httpResponder := getHttpResponder(config)
+	httpHandler, err := getHttpHandler(config, tokens)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if port := getHttp3Port(config); port != 0 {
+		httpHandler = advertiseHttp3(httpHandler, port)
+	}
+	tlsConfig := getTlsConfig(config)
+	httpResponder := getHttpResponder(config, httpHandler, tlsConfig)
 
 	for _, host := range config.ListenHosts {
 		for _, responder := range config.Responders {
@@ -190,10 +433,18 @@ func main() {
 					handlers = append(handlers, ohren.TcpListener{
 						Listener:    l,
 						Responder:   httpResponder,
-						Timeout:     1 * time.Second,
-						WorkerCount: 5,
+						Timeout:     5 * time.Second,
+						WorkerCount: getMaxConnections(config),
 					})
 				}
+			case ResponderTypeHttp3:
+				log.Printf("listening on udp port %d\n", port)
+				handlers = append(handlers, ohren.Http3Listener{
+					Addr:        fmt.Sprintf("%s:%d", host, port),
+					Handler:     httpHandler,
+					TLSConfig:   tlsConfig,
+					MaxBodySize: config.Http.MaxBodySize,
+				})
 			case ResponderTypeDns:
 				hostIp := net.ParseIP(host)
 				if hostIp == nil {
@@ -202,13 +453,25 @@ func main() {
 				log.Printf("listening on port %d\n", port)
 				handlers = append(handlers, ohren.UdpListener{
 					Addr: &net.UDPAddr{
-						IP:   net.IPv4(127, 0, 0, 1),
+						IP:   hostIp,
 						Port: port,
 					},
 					Responder:   dnsResponder,
 					Timeout:     1 * time.Second,
 					WorkerCount: 5,
 				})
+				// truncated answers are retried over tcp at the same address
+				l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: hostIp, Port: port})
+				if err != nil {
+					log.Fatalf("dns listener on tcp port %d failed: %s\n\n", port, err)
+				}
+				log.Printf("listening on tcp port %d\n", port)
+				handlers = append(handlers, ohren.TcpListener{
+					Listener:    l,
+					Responder:   dnsResponder,
+					Timeout:     1 * time.Second,
+					WorkerCount: 100,
+				})
 			}
 
 		}
@@ -273,23 +536,120 @@ func readConfigFile(file string) (*ServerConfig, error) {
 	return &config, nil
 }
 
-func getHttpResponder(config *ServerConfig) *ohren.MultiHttpResponder {
-	httpResponder := ohren.DefaultHttpResponder
-	if config.Http.Key != "" && config.Http.Certificate != "" {
-		cert, err := tls.LoadX509KeyPair(config.Http.Certificate, config.Http.Key)
-		if err != nil {
-			log.Fatalln(err)
+func getNotifiers(config *ServerConfig) (notifiers []notify.Notifier) {
+	for _, webhookConfig := range config.Webhooks {
+		webhook := notify.NewWebhook(webhookConfig.URL)
+		webhook.Secret = webhookConfig.Secret
+		webhook.Filter = webhookConfig.Filter
+		if webhookConfig.MaxRetries != 0 {
+			webhook.MaxRetries = webhookConfig.MaxRetries
+		}
+		if webhookConfig.InitialBackoff != 0 {
+			webhook.InitialBackoff = webhookConfig.InitialBackoff
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	for _, emailConfig := range config.Emails {
+		email := notify.NewEmail(emailConfig.SMTP, emailConfig.From, emailConfig.To)
+		if emailConfig.Username != "" {
+			if err := email.SetPlainAuth(emailConfig.Username, emailConfig.Password); err != nil {
+				log.Fatalln(err)
+			}
+		}
+		email.Filter = emailConfig.Filter
+		email.BatchInterval = emailConfig.BatchInterval
+		if emailConfig.MinInterval != 0 {
+			email.MinInterval = emailConfig.MinInterval
 		}
-		if cert.PrivateKey == nil {
-			log.Fatalln("no private key loaded")
+		if emailConfig.MaxInteractions != 0 {
+			email.MaxInteractions = emailConfig.MaxInteractions
 		}
-		httpResponder = httpResponder.WithTlsConfig(&tls.Config{
-			Certificates: []tls.Certificate{cert},
-		})
+		notifiers = append(notifiers, email)
+	}
+	return
+}
+
+func getAuthenticator(config *ServerConfig) *auth.Authenticator {
+	authenticator := auth.NewAuthenticator(config.Auth.APIKeys)
+	authenticator.SetBasicAuth(config.Auth.BasicAuth.Username, config.Auth.BasicAuth.Password)
+	authenticator.AllowedOrigins = config.Auth.AllowedOrigins
+	for _, user := range config.Users {
+		authenticator.AddUser(user.Name, user.Password, user.APIKeys)
+	}
+	return authenticator
+}
+
+// getTokensPath returns the file of the generated tokens or an empty string if
+// they are only kept in memory.
+func getTokensPath(config *ServerConfig) string {
+	if config.Store.TokensPath != "" || config.Store.Path == "" {
+		return config.Store.TokensPath
+	}
+	return strings.TrimSuffix(config.Store.Path, filepath.Ext(config.Store.Path)) + ".tokens"
+}
+
+func getStore(config *ServerConfig) (ohren.Store, error) {
+	if config.Store.Path == "" {
+		return ohren.NewMemoryStore(), nil
+	}
+	store, err := ohren.OpenFileStore(config.Store.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %s", err)
+	}
+	return store, nil
+}
+
+// defaultMaxConnections is the default maximum amount of connections served at
+// the same time by an HTTP listener.
+const defaultMaxConnections = 1000
+
+// getMaxConnections returns the maximum amount of connections served at the
+// same time by an HTTP listener.
+func getMaxConnections(config *ServerConfig) int {
+	if config.Http.MaxConnections <= 0 {
+		return defaultMaxConnections
+	}
+	return config.Http.MaxConnections
+}
+
+func getHttpResponder(config *ServerConfig, handler http.Handler, tlsConfig *tls.Config) *ohren.MultiHttpResponder {
+	httpResponder := &ohren.MultiHttpResponder{
+		HttpResponder: &ohren.HttpHandlerResponder{
+			Handler:        handler,
+			MaxBodySize:    config.Http.MaxBodySize,
+			MaxRequests:    config.Http.KeepAlive.MaxRequests,
+			IdleTimeout:    config.Http.KeepAlive.IdleTimeout,
+			MaxHeaderBytes: config.Http.MaxHeaderBytes,
+		},
+		Http2Responder: &ohren.Http2Responder{
+			Handler:     handler,
+			MaxBodySize: config.Http.MaxBodySize,
+			IdleTimeout: config.Http.KeepAlive.IdleTimeout,
+		},
+	}
+	if tlsConfig != nil {
+		httpResponder = httpResponder.WithTlsConfig(tlsConfig)
 	}
 	return httpResponder
 }
 
+// getTlsConfig returns the config with the certificate or nil if no certificate is configured.
+func getTlsConfig(config *ServerConfig) *tls.Config {
+	if config.Http.Key == "" || config.Http.Certificate == "" {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(config.Http.Certificate, config.Http.Key)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if cert.PrivateKey == nil {
+		log.Fatalln("no private key loaded")
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+}
+
 func getDnsResponder(config *ServerConfig) *ohren.DnsResponder {
 	publicIps := make([]net.IP, len(config.Dns.PublicIPs))
 	for i, rawIp := range config.Dns.PublicIPs {
@@ -299,9 +659,18 @@ func getDnsResponder(config *ServerConfig) *ohren.DnsResponder {
 		}
 		publicIps[i] = ip
 	}
+	records, err := getDnsRecords(config)
+	if err != nil {
+		log.Fatalln(err)
+	}
 	return &ohren.DnsResponder{
-		Addresses: publicIps,
-		TTL:       0,
+		Addresses:     publicIps,
+		TTL:           0,
+		Zone:          dns.Fqdn(config.Hostname),
+		Records:       records,
+		Foreign:       config.Dns.Foreign,
+		RecordForeign: config.Dns.RecordForeign,
+		Rebinding:     getDnsRebinding(&config.Dns.Rebinding),
 	}
 }
 
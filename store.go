@@ -0,0 +1,346 @@
+package ohren
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Query selects stored interactions. Zero values match all interactions.
+type Query struct {
+	// ID only matches the interaction with the ID
+	ID uint64
+	// Since only matches interactions started at or after the time
+	Since time.Time
+	// Until only matches interactions started before the time
+	Until time.Time
+	// AfterID only matches interactions with a greater ID
+	AfterID uint64
+	// Type matches the type of the interaction or its first word (e.g. "dns") ignoring the case
+	Type string
+	// Token only matches interactions tagged with the token
+	Token string
+	// Host only matches interactions with a host equal to or below the host
+	Host string
+	// RemoteAddress only matches interactions from the address
+	RemoteAddress string
+	// Owner only matches interactions of the owner
+	Owner string
+	// ConnectionID only matches interactions of requests on the connection
+	ConnectionID string
+	// Limit is the maximum amount of interactions to find
+	Limit int
+}
+
+func (q Query) Matches(interaction *Interaction) bool {
+	if q.ID != 0 && interaction.ID != q.ID {
+		return false
+	}
+	if interaction.ID <= q.AfterID {
+		return false
+	}
+	if !q.Since.IsZero() && interaction.StartTime.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !interaction.StartTime.Before(q.Until) {
+		return false
+	}
+	if q.Type != "" && !MatchesType(interaction.Type, q.Type) {
+		return false
+	}
+	if q.Token != "" && !containsString(interaction.Tokens, strings.ToLower(q.Token)) {
+		return false
+	}
+	if q.Host != "" && !anyHasHostSuffix(interaction.Hosts, q.Host) {
+		return false
+	}
+	if q.RemoteAddress != "" && interaction.RemoteAddress != q.RemoteAddress {
+		return false
+	}
+	if q.ConnectionID != "" && interaction.ConnectionID != q.ConnectionID {
+		return false
+	}
+	if q.Owner != "" && interaction.Owner != q.Owner {
+		return false
+	}
+	return true
+}
+
+// MatchesType returns true if the type of an interaction is equal to the
+// requested type or its first word (e.g. "dns") ignoring the case.
+func MatchesType(interactionType string, requestedType string) bool {
+	if strings.EqualFold(interactionType, requestedType) {
+		return true
+	}
+	typeWords := strings.Fields(interactionType)
+	return len(typeWords) > 0 && strings.EqualFold(typeWords[0], requestedType)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func anyHasHostSuffix(hosts []string, suffix string) bool {
+	for _, host := range hosts {
+		if HasHostSuffix(host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasHostSuffix returns true if the host is equal to the suffix or a subdomain of it.
+func HasHostSuffix(host string, suffix string) bool {
+	host = NormalizeHost(host)
+	suffix = NormalizeHost(suffix)
+	return host == suffix || strings.HasSuffix(host, "."+suffix)
+}
+
+// NormalizeHost converts the host to lowercase and removes the port and the trailing dot.
+func NormalizeHost(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+	return strings.ToLower(strings.TrimSuffix(host, "."))
+}
+
+// Store persists interactions.
+type Store interface {
+	// Add assigns a new ID to the interaction and stores it.
+	Add(interaction *Interaction) error
+	// Find returns all interactions matching the query ordered by their ID.
+	Find(query Query) ([]*Interaction, error)
+	// Delete removes all interactions matching the query and returns the amount of deleted interactions.
+	Delete(query Query) (int, error)
+}
+
+// StoreRecords adds all records from in to the store and passes them on to out
+// with their assigned ID. out is closed when in was closed.
+func StoreRecords(store Store, in chan Record, out chan Record) {
+	for record := range in {
+		interaction := NewInteraction(record)
+		if interaction != nil {
+			if err := store.Add(interaction); err != nil {
+				log.Printf("failed to store record: %s", err)
+			} else {
+				record.ID = interaction.ID
+			}
+		}
+		out <- record
+	}
+	close(out)
+}
+
+// MemoryStore keeps the interactions in memory only.
+type MemoryStore struct {
+	mutex        sync.RWMutex
+	interactions []*Interaction
+	lastID       uint64
+}
+
+func NewMemoryStore() *MemoryStore {
+	return new(MemoryStore)
+}
+
+func (m *MemoryStore) Add(interaction *Interaction) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.lastID++
+	interaction.ID = m.lastID
+	m.interactions = append(m.interactions, interaction)
+	return nil
+}
+
+// insert adds an interaction which already has an ID.
+func (m *MemoryStore) insert(interaction *Interaction) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if interaction.ID > m.lastID {
+		m.lastID = interaction.ID
+	}
+	m.interactions = append(m.interactions, interaction)
+}
+
+func (m *MemoryStore) nextID() uint64 {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	return m.lastID + 1
+}
+
+func (m *MemoryStore) Find(query Query) ([]*Interaction, error) {
+	m.mutex.RLock()
+	defer m.mutex.RUnlock()
+	var result []*Interaction
+	for _, interaction := range m.interactions {
+		if query.Limit > 0 && len(result) >= query.Limit {
+			break
+		}
+		if query.Matches(interaction) {
+			result = append(result, interaction)
+		}
+	}
+	return result, nil
+}
+
+func (m *MemoryStore) Delete(query Query) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	kept := m.interactions[:0]
+	deleted := 0
+	for _, interaction := range m.interactions {
+		if (query.Limit <= 0 || deleted < query.Limit) && query.Matches(interaction) {
+			deleted++
+		} else {
+			kept = append(kept, interaction)
+		}
+	}
+	for i := len(kept); i < len(m.interactions); i++ {
+		m.interactions[i] = nil
+	}
+	m.interactions = kept
+	return deleted, nil
+}
+
+// FileStore appends every interaction as a JSON line to a file. The file is read
+// once when opened and afterwards served from memory. Deleting interactions
+// rewrites the whole file starting with a header line, which keeps the last
+// assigned ID, so the IDs of deleted interactions are never reused.
+type FileStore struct {
+	mutex   sync.Mutex
+	path    string
+	file    *os.File
+	encoder *json.Encoder
+	memory  *MemoryStore
+}
+
+// fileStoreHeader is the first line of a rewritten file.
+type fileStoreHeader struct {
+	// LastID is the highest ID ever assigned
+	LastID uint64 `json:"last_id"`
+}
+
+func OpenFileStore(path string) (*FileStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	store := &FileStore{
+		path:    path,
+		file:    file,
+		encoder: json.NewEncoder(file),
+		memory:  NewMemoryStore(),
+	}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		if line == 1 {
+			var header fileStoreHeader
+			if err := json.Unmarshal(scanner.Bytes(), &header); err == nil && header.LastID != 0 {
+				store.memory.lastID = header.LastID
+				continue
+			}
+		}
+		interaction := new(Interaction)
+		if err := json.Unmarshal(scanner.Bytes(), interaction); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		store.memory.insert(interaction)
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return store, nil
+}
+
+func (f *FileStore) Add(interaction *Interaction) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	interaction.ID = f.memory.nextID()
+	if err := f.encoder.Encode(interaction); err != nil {
+		interaction.ID = 0
+		return err
+	}
+	f.memory.insert(interaction)
+	return nil
+}
+
+func (f *FileStore) Find(query Query) ([]*Interaction, error) {
+	return f.memory.Find(query)
+}
+
+func (f *FileStore) Delete(query Query) (int, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	deleted, err := f.memory.Delete(query)
+	if err != nil || deleted == 0 {
+		return deleted, err
+	}
+	return deleted, f.rewrite()
+}
+
+// rewrite replaces the file with the interactions in memory.
+func (f *FileStore) rewrite() error {
+	interactions, err := f.memory.Find(Query{})
+	if err != nil {
+		return err
+	}
+	tmpPath := f.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	err = encoder.Encode(fileStoreHeader{LastID: f.memory.nextID() - 1})
+	for _, interaction := range interactions {
+		if err != nil {
+			break
+		}
+		err = encoder.Encode(interaction)
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, f.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	f.file.Close()
+	f.file = file
+	f.encoder = json.NewEncoder(file)
+	return nil
+}
+
+func (f *FileStore) Close() error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.file.Close()
+}
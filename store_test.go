@@ -0,0 +1,119 @@
+package ohren
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileStoreIDs(t *testing.T) {
+	tests := []struct {
+		name string
+		// deleted are the IDs deleted before the store is reopened
+		deleted []uint64
+		kept    []uint64
+		next    uint64
+	}{
+		{name: "nothing deleted", kept: []uint64{1, 2, 3}, next: 4},
+		{name: "oldest deleted", deleted: []uint64{1}, kept: []uint64{2, 3}, next: 4},
+		{name: "newest deleted", deleted: []uint64{3}, kept: []uint64{1, 2}, next: 4},
+		{name: "all deleted", deleted: []uint64{1, 2, 3}, next: 4},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "interactions.jsonl")
+			store, err := OpenFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 3; i++ {
+				if err := store.Add(&Interaction{Type: string(RequestTypeDNS)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, id := range test.deleted {
+				if deleted, err := store.Delete(Query{ID: id}); err != nil || deleted != 1 {
+					t.Fatalf("deleted %d interactions with ID %d: %v", deleted, id, err)
+				}
+			}
+			if err := store.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			store, err = OpenFileStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer store.Close()
+			interactions, err := store.Find(Query{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(interactions) != len(test.kept) {
+				t.Fatalf("%d interactions after reopening, want %v", len(interactions), test.kept)
+			}
+			for i, interaction := range interactions {
+				if interaction.ID != test.kept[i] {
+					t.Errorf("interaction %d has ID %d, want %d", i+1, interaction.ID, test.kept[i])
+				}
+			}
+			interaction := new(Interaction)
+			if err := store.Add(interaction); err != nil {
+				t.Fatal(err)
+			}
+			if interaction.ID != test.next {
+				t.Errorf("new interaction has ID %d, want %d", interaction.ID, test.next)
+			}
+		})
+	}
+}
+
+func TestQueryMatches(t *testing.T) {
+	start := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	interaction := &Interaction{
+		ID:            5,
+		Type:          string(RequestTypeHttp),
+		Hosts:         []string{"abc.Example.com:8080"},
+		Tokens:        []string{"abc"},
+		Owner:         "red",
+		StartTime:     start,
+		RemoteAddress: "192.0.2.1",
+		ConnectionID:  "c1",
+	}
+	tests := []struct {
+		name    string
+		query   Query
+		matches bool
+	}{
+		{name: "empty", query: Query{}, matches: true},
+		{name: "id", query: Query{ID: 5}, matches: true},
+		{name: "other id", query: Query{ID: 4}},
+		{name: "after id", query: Query{AfterID: 4}, matches: true},
+		{name: "after own id", query: Query{AfterID: 5}},
+		{name: "since start", query: Query{Since: start}, matches: true},
+		{name: "since later", query: Query{Since: start.Add(time.Second)}},
+		{name: "until start", query: Query{Until: start}},
+		{name: "until later", query: Query{Until: start.Add(time.Second)}, matches: true},
+		{name: "type", query: Query{Type: "HTTP connection"}, matches: true},
+		{name: "type word", query: Query{Type: "http"}, matches: true},
+		{name: "other type", query: Query{Type: "dns"}},
+		{name: "token", query: Query{Token: "ABC"}, matches: true},
+		{name: "other token", query: Query{Token: "abd"}},
+		{name: "host", query: Query{Host: "abc.example.com"}, matches: true},
+		{name: "parent host", query: Query{Host: "example.com."}, matches: true},
+		{name: "host suffix", query: Query{Host: "c.example.com"}},
+		{name: "remote address", query: Query{RemoteAddress: "192.0.2.1"}, matches: true},
+		{name: "other remote address", query: Query{RemoteAddress: "192.0.2.2"}},
+		{name: "owner", query: Query{Owner: "red"}, matches: true},
+		{name: "other owner", query: Query{Owner: "blue"}},
+		{name: "connection", query: Query{ConnectionID: "c1"}, matches: true},
+		{name: "other connection", query: Query{ConnectionID: "c2"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := test.query.Matches(interaction); matches != test.matches {
+				t.Errorf("Matches = %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
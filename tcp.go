@@ -2,6 +2,9 @@ package ohren
 
 import (
 	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
 	"io"
 	"log"
 	"net"
@@ -21,6 +24,30 @@ type Responder interface {
 	Respond(conn net.Conn) (RequestDetails, error)
 }
 
+// EmitFunc passes the details of a request received at start.
+type EmitFunc func(details RequestDetails, start time.Time)
+
+// StreamResponder is implemented by responders which respond to multiple requests
+// on the same connection. The details of every request are passed to emit.
+type StreamResponder interface {
+	Responder
+	RespondStream(conn net.Conn, emit EmitFunc) error
+}
+
+// RespondStream responds with the responder and passes the details to emit. Responders
+// which are no StreamResponder respond to a single request.
+func RespondStream(responder Responder, conn net.Conn, emit EmitFunc) error {
+	if streamResponder, ok := responder.(StreamResponder); ok {
+		return streamResponder.RespondStream(conn, emit)
+	}
+	start := time.Now()
+	details, err := responder.Respond(conn)
+	if details != nil {
+		emit(details, start)
+	}
+	return err
+}
+
 type Listener interface {
 	Record( chan Record) error
 }
@@ -46,7 +73,13 @@ func mustGetPort(addr net.Addr) int {
 type TcpListener struct {
 	Listener  net.Listener
 	Responder Responder
-	Timeout   time.Duration
+	// Timeout limits the time until the first bytes of a connection are read,
+	// e.g. the TLS handshake. Responders reading further requests set their own
+	// deadlines.
+	Timeout time.Duration
+	// WorkerCount limits the connections served at the same time if it is
+	// positive, further connections wait. Every connection occupies a worker
+	// until it is closed, so slow clients can block the listener.
 	WorkerCount int
 }
 
@@ -57,48 +90,105 @@ func ProcessConnection(conn net.Conn, timeout time.Duration, responder Responder
 	record.SetLocalAddress(conn.LocalAddr())
 	record.SetRemoteAddress(conn.RemoteAddr())
 	details, err := responder.Respond(conn)
-	if err != nil {
+	if err != nil && err != ErrNotRecorded {
 		log.Printf("error responding: %s\n", err)
 	}
 
 	record.EndTime = time.Now()
 
 	record.Details = details
+	record.Error = err
 	return record
 }
 
-func (h TcpListener) handleConnection(connections chan net.Conn, out chan Record) {
-	for conn := range connections {
-		out <- ProcessConnection(conn, h.Timeout, h.Responder)
-		if err := conn.Close(); err != nil {
-			log.Printf("failed to close connection: %s", err)
-		} else {
-			log.Printf("connection from %s to %s closed", conn.RemoteAddr(), conn.LocalAddr())
-		}
+// ProcessConnectionStream passes a record for every request on the connection to out.
+// The records share the ConnectionID. If the responder did not emit any details, a
+// single record without details is passed to out.
+func ProcessConnectionStream(conn net.Conn, responder Responder, out chan Record) {
+	log.Printf("processing connection: %s", conn.RemoteAddr())
+	connectionStart := time.Now()
+	connectionID := newConnectionID()
+	var count int
+	var mutex sync.Mutex
+	err := RespondStream(responder, conn, func(details RequestDetails, start time.Time) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		count++
+		record := new(RecordedConnection)
+		record.StartTime = start
+		record.EndTime = time.Now()
+		record.SetLocalAddress(conn.LocalAddr())
+		record.SetRemoteAddress(conn.RemoteAddr())
+		record.Details = details
+		record.ConnectionID = connectionID
+		record.RequestNumber = count
+		out <- record
+	})
+	if err == ErrNotRecorded {
+		return
+	}
+	if err != nil {
+		log.Printf("error responding: %s\n", err)
+	}
+	if count == 0 {
+		record := new(RecordedConnection)
+		record.StartTime = connectionStart
+		record.EndTime = time.Now()
+		record.SetLocalAddress(conn.LocalAddr())
+		record.SetRemoteAddress(conn.RemoteAddr())
+		record.ConnectionID = connectionID
+		record.Error = err
+		out <- record
 	}
 }
 
-func (h TcpListener) Record(out chan Record) error {
-	var err error
-	var con net.Conn
-	var conChannel = make(chan net.Conn)
-	var wg = new(sync.WaitGroup)
+// newConnectionID returns a random ID for the records of a connection.
+func newConnectionID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
 
-	for i := 0; i < h.WorkerCount; i++ {
-		wg.Add(1)
-		go func() {
-			h.handleConnection(conChannel, out)
-			wg.Done()
-		}()
+func (h TcpListener) handleConnection(conn net.Conn, out chan Record) {
+	if h.Timeout > 0 {
+		if err := conn.SetReadDeadline(time.Now().Add(h.Timeout)); err != nil {
+			log.Printf("failed to set deadline: %s", err)
+		}
+	}
+	ProcessConnectionStream(conn, h.Responder, out)
+	// handlers of hijacked connections may have closed them already
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Printf("failed to close connection: %s", err)
+	} else {
+		log.Printf("connection from %s to %s closed", conn.RemoteAddr(), conn.LocalAddr())
 	}
+}
 
+// Record serves every connection in its own goroutine until the listener fails.
+func (h TcpListener) Record(out chan Record) error {
+	var workers chan struct{}
+	if h.WorkerCount > 0 {
+		workers = make(chan struct{}, h.WorkerCount)
+	}
+	var wg = new(sync.WaitGroup)
 	for {
-		con, err = h.Listener.Accept()
+		con, err := h.Listener.Accept()
 		if err != nil {
-			close(conChannel)
 			wg.Wait()
 			return err
 		}
-		conChannel <- con
+		if workers != nil {
+			workers <- struct{}{}
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.handleConnection(con, out)
+			if workers != nil {
+				<-workers
+			}
+		}()
 	}
 }
@@ -9,20 +9,49 @@ import (
 type TlsResponder struct {
 	Config *tls.Config
 	PlainResponder Responder
+	// ProtocolResponders are used instead of the PlainResponder if their protocol
+	// was negotiated by ALPN
+	ProtocolResponders map[string]Responder
 }
 
 func (t TlsResponder) Respond(conn net.Conn) (RequestDetails, error) {
-	var err error
+	tlsConn, err := t.handshake(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer closeTlsConn(tlsConn)
+	return t.responder(tlsConn).Respond(tlsConn)
+}
+
+func (t TlsResponder) RespondStream(conn net.Conn, emit EmitFunc) error {
+	tlsConn, err := t.handshake(conn)
+	if err != nil {
+		return err
+	}
+	defer closeTlsConn(tlsConn)
+	return RespondStream(t.responder(tlsConn), tlsConn, emit)
+}
+
+// responder returns the responder for the negotiated protocol.
+func (t TlsResponder) responder(tlsConn *tls.Conn) Responder {
+	if responder, ok := t.ProtocolResponders[tlsConn.ConnectionState().NegotiatedProtocol]; ok {
+		return responder
+	}
+	return t.PlainResponder
+}
+
+func (t TlsResponder) handshake(conn net.Conn) (*tls.Conn, error) {
 	tlsConn := tls.Server(conn, t.Config)
-	err = tlsConn.Handshake()
+	err := tlsConn.Handshake()
 	if err != nil {
 		return nil, err
 	}
-	defer func(tlsConn *tls.Conn) {
-		err := tlsConn.Close()
-		if err != nil {
-			log.Println(err)
-		}
-	}(tlsConn)
-	return t.PlainResponder.Respond(tlsConn)
+	return tlsConn, nil
+}
+
+func closeTlsConn(tlsConn *tls.Conn) {
+	err := tlsConn.Close()
+	if err != nil {
+		log.Println(err)
+	}
 }
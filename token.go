@@ -0,0 +1,348 @@
+package ohren
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenBytes is the amount of random bytes used for a token, resulting in
+// 16 base32 characters.
+const tokenBytes = 10
+
+// MaxTokensPerRequest limits the amount of tokens which can be generated at once.
+const MaxTokensPerRequest = 100
+
+// tokenEncoding only uses characters which are valid in a hostname and does not
+// depend on the case, because resolvers may randomize it.
+var tokenEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// SearchableDetails is implemented by request details which contain content
+// besides the hosts in which a correlation token may be hidden.
+type SearchableDetails interface {
+	SearchableContent() []string
+}
+
+type tokenInfo struct {
+	created time.Time
+	owner   string
+}
+
+// tokenEntry is a line of the token file. Removed tokens are appended again
+// with Removed set.
+type tokenEntry struct {
+	Token   string    `json:"token"`
+	Owner   string    `json:"owner,omitempty"`
+	Created time.Time `json:"created"`
+	Removed bool      `json:"removed,omitempty"`
+}
+
+// TokenRegistry generates random correlation tokens and tags records containing them.
+// Every owner can have a namespace below the hostname. Records are owned by the
+// owner of the tokens they contain or by the owner of the namespace they are sent to.
+type TokenRegistry struct {
+	Hostname   string
+	mutex      sync.RWMutex
+	tokens     map[string]tokenInfo
+	namespaces map[string]string
+	path       string
+	file       *os.File
+	encoder    *json.Encoder
+	// removed is the amount of removed tokens in the file
+	removed int
+}
+
+func NewTokenRegistry(hostname string) *TokenRegistry {
+	return &TokenRegistry{
+		Hostname:   NormalizeHost(hostname),
+		tokens:     make(map[string]tokenInfo),
+		namespaces: make(map[string]string),
+	}
+}
+
+// Open loads the tokens of the file and appends every generated or removed token
+// to it, so records are still tagged with tokens generated before a restart.
+// The file is rewritten without the removed tokens when it is opened and when
+// it contains more removed than registered tokens.
+func (t *TokenRegistry) Open(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	tokens := make(map[string]tokenInfo)
+	removed := 0
+	scanner := bufio.NewScanner(file)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry tokenEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			file.Close()
+			return fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		if entry.Removed {
+			delete(tokens, entry.Token)
+			removed++
+		} else {
+			tokens[entry.Token] = tokenInfo{
+				created: entry.Created,
+				owner:   entry.Owner,
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return err
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for token, info := range tokens {
+		t.tokens[token] = info
+	}
+	t.path = path
+	t.file = file
+	t.encoder = json.NewEncoder(file)
+	t.removed = removed
+	if removed > 0 {
+		return t.compact()
+	}
+	return nil
+}
+
+// compact replaces the file with the registered tokens.
+func (t *TokenRegistry) compact() error {
+	tokens := make([]string, 0, len(t.tokens))
+	for token := range t.tokens {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return t.tokens[tokens[i]].created.Before(t.tokens[tokens[j]].created)
+	})
+	tmpPath := t.path + ".tmp"
+	tmpFile, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+	for _, token := range tokens {
+		info := t.tokens[token]
+		if err = encoder.Encode(tokenEntry{Token: token, Owner: info.owner, Created: info.created}); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	t.file.Close()
+	t.file = file
+	t.encoder = json.NewEncoder(file)
+	t.removed = 0
+	return nil
+}
+
+// Close closes the file opened by Open.
+func (t *TokenRegistry) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	t.encoder = nil
+	return err
+}
+
+// SetNamespace assigns the subdomain namespace.<hostname> to the owner.
+func (t *TokenRegistry) SetNamespace(owner string, namespace string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.namespaces[owner] = NormalizeHost(namespace)
+}
+
+// Namespace returns the domain owned by the owner or the hostname if the owner has no namespace.
+func (t *TokenRegistry) Namespace(owner string) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.namespace(owner)
+}
+
+func (t *TokenRegistry) namespace(owner string) string {
+	namespace, ok := t.namespaces[owner]
+	if !ok || namespace == "" {
+		return t.Hostname
+	}
+	if t.Hostname == "" {
+		return namespace
+	}
+	return namespace + "." + t.Hostname
+}
+
+// Generate creates and registers a new random token for the owner.
+func (t *TokenRegistry) Generate(owner string) (string, error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", err
+	}
+	info := tokenInfo{
+		created: time.Now(),
+		owner:   owner,
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.encoder != nil {
+		if err := t.encoder.Encode(tokenEntry{Token: token, Owner: owner, Created: info.created}); err != nil {
+			return "", err
+		}
+	}
+	t.tokens[token] = info
+	return token, nil
+}
+
+// Remove forgets the token, records containing it are no longer tagged.
+func (t *TokenRegistry) Remove(token string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	info, ok := t.tokens[token]
+	if !ok {
+		return nil
+	}
+	delete(t.tokens, token)
+	if t.encoder == nil {
+		return nil
+	}
+	if err := t.encoder.Encode(tokenEntry{Token: token, Owner: info.owner, Created: info.created, Removed: true}); err != nil {
+		return err
+	}
+	t.removed++
+	if t.removed > len(t.tokens) {
+		return t.compact()
+	}
+	return nil
+}
+
+// randomToken returns a new random token which is not registered.
+func randomToken() (string, error) {
+	randomBytes := make([]byte, tokenBytes)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", err
+	}
+	return tokenEncoding.EncodeToString(randomBytes), nil
+}
+
+// Host returns the hostname to use as payload for the token.
+func (t *TokenRegistry) Host(token string) string {
+	t.mutex.RLock()
+	domain := t.namespace(t.tokens[token].owner)
+	t.mutex.RUnlock()
+	if domain == "" {
+		return token
+	}
+	return token + "." + domain
+}
+
+// Match returns all registered tokens which are contained in the record.
+func (t *TokenRegistry) Match(record Record) []string {
+	if record.Details == nil {
+		return nil
+	}
+	content := record.Details.Hosts()
+	if searchable, ok := record.Details.(SearchableDetails); ok {
+		content = append(content[:len(content):len(content)], searchable.SearchableContent()...)
+	}
+	return t.Find(content...)
+}
+
+// Find returns all registered tokens which are contained in the content.
+func (t *TokenRegistry) Find(content ...string) (tokens []string) {
+	lowered := make([]string, len(content))
+	for i, c := range content {
+		lowered[i] = strings.ToLower(c)
+	}
+
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for token := range t.tokens {
+		for _, c := range lowered {
+			if strings.Contains(c, token) {
+				tokens = append(tokens, token)
+				break
+			}
+		}
+	}
+	sort.Strings(tokens)
+	return
+}
+
+// Owner returns the owner of the first token with an owner or the owner of the
+// namespace one of the hosts belongs to.
+func (t *TokenRegistry) Owner(record Record) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for _, token := range record.Tokens {
+		if owner := t.tokens[token].owner; owner != "" {
+			return owner
+		}
+	}
+	if record.Details == nil {
+		return ""
+	}
+	for _, host := range record.Details.Hosts() {
+		if owner := t.hostOwner(host); owner != "" {
+			return owner
+		}
+	}
+	return ""
+}
+
+// HostOwner returns the owner of the namespace the host belongs to.
+func (t *TokenRegistry) HostOwner(host string) string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.hostOwner(host)
+}
+
+func (t *TokenRegistry) hostOwner(host string) string {
+	for owner, namespace := range t.namespaces {
+		if namespace != "" && HasHostSuffix(host, t.namespace(owner)) {
+			return owner
+		}
+	}
+	return ""
+}
+
+// Tag sets the tokens and the owner of all records from in and passes them on to out.
+// out is closed when in was closed.
+func (t *TokenRegistry) Tag(in chan Record, out chan Record) {
+	for record := range in {
+		record.Tokens = t.Match(record)
+		record.Owner = t.Owner(record)
+		out <- record
+	}
+	close(out)
+}
@@ -0,0 +1,83 @@
+package ohren
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestTokenRegistryFile(t *testing.T) {
+	tests := []struct {
+		name      string
+		generated int
+		removed   int
+		// lines is the amount of lines in the file before it is reopened
+		lines int
+	}{
+		{name: "nothing removed", generated: 3, lines: 3},
+		// two generated and one removed token
+		{name: "fewer removed than registered", generated: 5, removed: 2, lines: 7},
+		{name: "more removed than registered", generated: 5, removed: 3, lines: 2},
+		{name: "all removed", generated: 2, removed: 2, lines: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "interactions.tokens")
+			registry := NewTokenRegistry("example.com")
+			if err := registry.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			var tokens []string
+			for i := 0; i < test.generated; i++ {
+				token, err := registry.Generate("red")
+				if err != nil {
+					t.Fatal(err)
+				}
+				tokens = append(tokens, token)
+			}
+			for _, token := range tokens[:test.removed] {
+				if err := registry.Remove(token); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if lines := countLines(t, path); lines != test.lines {
+				t.Errorf("%d lines in the file, want %d", lines, test.lines)
+			}
+			if err := registry.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			registry = NewTokenRegistry("example.com")
+			if err := registry.Open(path); err != nil {
+				t.Fatal(err)
+			}
+			defer registry.Close()
+			kept := append([]string{}, tokens[test.removed:]...)
+			sort.Strings(kept)
+			// removed tokens are dropped from the file when it is opened
+			if lines := countLines(t, path); lines != len(kept) {
+				t.Errorf("%d lines in the reopened file, want %d", lines, len(kept))
+			}
+			found := registry.Find(strings.Join(tokens, " "))
+			if strings.Join(found, " ") != strings.Join(kept, " ") {
+				t.Errorf("found %v after reopening, want %v", found, kept)
+			}
+			for _, token := range kept {
+				if owner := registry.tokens[token].owner; owner != "red" {
+					t.Errorf("token %s is owned by %q", token, owner)
+				}
+			}
+		})
+	}
+}
+
+func countLines(t *testing.T, path string) int {
+	t.Helper()
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Count(string(content), "\n")
+}
@@ -23,6 +23,10 @@ func (u UdpListener) Record(records chan Record) error {
 	defer pc.Close()
 	for {
 		udpConn.RemoteAddr()
-		records <- ProcessConnection(udpConn, u.Timeout, u.Responder)
+		record := ProcessConnection(udpConn, u.Timeout, u.Responder)
+		if record.Error == ErrNotRecorded {
+			continue
+		}
+		records <- record
 	}
 }
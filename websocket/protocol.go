@@ -1,12 +1,51 @@
 package websocket
 
+import "time"
+
 type ClientMessageType int
 
 type ClientMessage struct {
 	Type            ClientMessageType
 	RequestedAmount int `json:"ra,omitempty"`
+	// Tokens to subscribe to or unsubscribe from
+	Tokens []string `json:"tokens,omitempty"`
+	// Suffixes are host suffixes to subscribe to or unsubscribe from
+	Suffixes []string `json:"suffixes,omitempty"`
+	// Since requests the replay of the stored interactions started since the time
+	Since time.Time `json:"since,omitempty"`
+	// AfterID requests the replay of the stored interactions with a greater ID
+	AfterID uint64 `json:"after_id,omitempty"`
+}
+
+var (
+	TypeGenerate    ClientMessageType = 1
+	TypeSubscribe   ClientMessageType = 2
+	TypeUnsubscribe ClientMessageType = 3
+	// TypeReplay requests all stored interactions matching the subscriptions
+	TypeReplay ClientMessageType = 4
+)
+
+type ServerMessageType int
+
+// ServerMessage is sent to the client as an answer to a ClientMessage.
+type ServerMessage struct {
+	Type ServerMessageType
+	// Tokens contains the generated correlation tokens
+	Tokens []string `json:"tokens,omitempty"`
+	// Hosts contains the hostnames to use for the generated tokens
+	Hosts []string `json:"hosts,omitempty"`
+	// Suffixes contains the subscribed host suffixes
+	Suffixes []string `json:"suffixes,omitempty"`
+	// Count is the amount of replayed interactions
+	Count int `json:"count,omitempty"`
 }
 
 var (
-	TypeGenerate ClientMessageType = 1
+	// TypeTokens answers TypeGenerate. The generated tokens are subscribed automatically.
+	TypeTokens ServerMessageType = 1
+	// TypeSubscriptions answers TypeSubscribe and TypeUnsubscribe with all current subscriptions.
+	TypeSubscriptions ServerMessageType = 2
+	// TypeReplayed is sent after all interactions requested by TypeReplay have been sent.
+	// Interactions recorded during the replay may be sent twice.
+	TypeReplayed ServerMessageType = 3
 )
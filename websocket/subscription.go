@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"github.com/coffeemakr/ohren"
+	"strings"
+	"sync"
+)
+
+// Subscription decides which records are sent to a client.
+// A record is sent if it is tagged with one of the tokens or if one of its
+// hosts ends with one of the suffixes.
+type Subscription struct {
+	mutex    sync.RWMutex
+	tokens   map[string]bool
+	suffixes map[string]bool
+}
+
+func NewSubscription() *Subscription {
+	return &Subscription{
+		tokens:   make(map[string]bool),
+		suffixes: make(map[string]bool),
+	}
+}
+
+func (s *Subscription) Add(tokens []string, suffixes []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range tokens {
+		s.tokens[strings.ToLower(token)] = true
+	}
+	for _, suffix := range suffixes {
+		suffix = ohren.NormalizeHost(suffix)
+		if suffix != "" {
+			s.suffixes[suffix] = true
+		}
+	}
+}
+
+func (s *Subscription) Remove(tokens []string, suffixes []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, token := range tokens {
+		delete(s.tokens, strings.ToLower(token))
+	}
+	for _, suffix := range suffixes {
+		delete(s.suffixes, ohren.NormalizeHost(suffix))
+	}
+}
+
+// List returns the subscribed tokens and suffixes.
+func (s *Subscription) List() (tokens []string, suffixes []string) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	tokens = make([]string, 0, len(s.tokens))
+	for token := range s.tokens {
+		tokens = append(tokens, token)
+	}
+	suffixes = make([]string, 0, len(s.suffixes))
+	for suffix := range s.suffixes {
+		suffixes = append(suffixes, suffix)
+	}
+	return
+}
+
+func (s *Subscription) Matches(interaction *ohren.Interaction) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, token := range interaction.Tokens {
+		if s.tokens[token] {
+			return true
+		}
+	}
+	for _, host := range interaction.Hosts {
+		for suffix := range s.suffixes {
+			if ohren.HasHostSuffix(host, suffix) {
+				return true
+			}
+		}
+	}
+	return false
+}
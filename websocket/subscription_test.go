@@ -0,0 +1,100 @@
+package websocket
+
+import (
+	"github.com/coffeemakr/ohren"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSubscriptionMatches(t *testing.T) {
+	tests := []struct {
+		name            string
+		tokens          []string
+		suffixes        []string
+		removedTokens   []string
+		removedSuffixes []string
+		interaction     *ohren.Interaction
+		matches         bool
+	}{
+		{
+			name:        "nothing subscribed",
+			interaction: &ohren.Interaction{Hosts: []string{"abc.d.example"}, Tokens: []string{"abc"}},
+			matches:     false,
+		},
+		{
+			name:        "token",
+			tokens:      []string{"ABC"},
+			interaction: &ohren.Interaction{Hosts: []string{"abc.d.example"}, Tokens: []string{"abc"}},
+			matches:     true,
+		},
+		{
+			name:        "other token",
+			tokens:      []string{"xyz"},
+			interaction: &ohren.Interaction{Hosts: []string{"abc.d.example"}, Tokens: []string{"abc"}},
+			matches:     false,
+		},
+		{
+			name:        "suffix",
+			suffixes:    []string{"D.Example."},
+			interaction: &ohren.Interaction{Hosts: []string{"x.abc.d.example:8080"}},
+			matches:     true,
+		},
+		{
+			name:        "suffix equals host",
+			suffixes:    []string{"d.example"},
+			interaction: &ohren.Interaction{Hosts: []string{"d.example"}},
+			matches:     true,
+		},
+		{
+			name:        "suffix is no label boundary",
+			suffixes:    []string{"example"},
+			interaction: &ohren.Interaction{Hosts: []string{"d.otherexample"}},
+			matches:     false,
+		},
+		{
+			name:        "empty suffix is ignored",
+			suffixes:    []string{"."},
+			interaction: &ohren.Interaction{Hosts: []string{"d.example"}},
+			matches:     false,
+		},
+		{
+			name:          "removed token",
+			tokens:        []string{"abc", "xyz"},
+			removedTokens: []string{"Abc"},
+			interaction:   &ohren.Interaction{Tokens: []string{"abc"}},
+			matches:       false,
+		},
+		{
+			name:            "removed suffix",
+			suffixes:        []string{"d.example"},
+			removedSuffixes: []string{"d.example."},
+			interaction:     &ohren.Interaction{Hosts: []string{"abc.d.example"}},
+			matches:         false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			subscription := NewSubscription()
+			subscription.Add(test.tokens, test.suffixes)
+			subscription.Remove(test.removedTokens, test.removedSuffixes)
+			if matches := subscription.Matches(test.interaction); matches != test.matches {
+				t.Errorf("matches = %v, want %v", matches, test.matches)
+			}
+		})
+	}
+}
+
+func TestSubscriptionList(t *testing.T) {
+	subscription := NewSubscription()
+	subscription.Add([]string{"B", "a"}, []string{"X.example.", "y.example:53"})
+	subscription.Remove([]string{"b"}, nil)
+	tokens, suffixes := subscription.List()
+	sort.Strings(suffixes)
+	if !reflect.DeepEqual(tokens, []string{"a"}) {
+		t.Errorf("tokens %v", tokens)
+	}
+	if !reflect.DeepEqual(suffixes, []string{"x.example", "y.example"}) {
+		t.Errorf("suffixes %v", suffixes)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
 	"github.com/gorilla/websocket"
 	"log"
 	"net/http"
@@ -11,9 +12,18 @@ import (
 )
 
 type WebsocketClient struct {
-	Connection *websocket.Conn
-	Send       chan ohren.Record
-	OnClose    func(client *WebsocketClient)
+	Connection   *websocket.Conn
+	Send         chan *ohren.Interaction
+	Replies      chan *ServerMessage
+	Replays      chan ohren.Query
+	Tokens       *ohren.TokenRegistry
+	Store        ohren.Store
+	Subscription *Subscription
+	// User is the authenticated user of the connection
+	User    *auth.User
+	OnClose func(client *WebsocketClient)
+	// done is closed when the write pump stopped
+	done chan struct{}
 }
 
 const (
@@ -28,6 +38,9 @@ const (
 
 	// Maximum Record size allowed from peer.
 	maxMessageSize = 512
+
+	// Interactions buffered for a client before it is dropped by the hub.
+	sendBufferSize = 256
 )
 
 func (c *WebsocketClient) readPump() {
@@ -52,27 +65,83 @@ func (c *WebsocketClient) readPump() {
 		if messageType == websocket.TextMessage {
 			var clientMessage ClientMessage
 			decoder := json.NewDecoder(bytes.NewReader(message))
-			decoder.Decode(&clientMessage)
+			if err := decoder.Decode(&clientMessage); err != nil {
+				log.Printf("invalid client message: %s", err)
+				continue
+			}
+			c.handleMessage(&clientMessage)
+		}
+	}
+}
+
+// accepts returns true if the interaction may and should be sent to the client.
+func (c *WebsocketClient) accepts(interaction *ohren.Interaction) bool {
+	return c.User.CanAccess(interaction.Owner) && c.Subscription.Matches(interaction)
+}
+
+func (c *WebsocketClient) handleMessage(message *ClientMessage) {
+	switch message.Type {
+	case TypeGenerate:
+		amount := message.RequestedAmount
+		if amount <= 0 {
+			amount = 1
+		} else if amount > ohren.MaxTokensPerRequest {
+			amount = ohren.MaxTokensPerRequest
+		}
+		reply := &ServerMessage{
+			Type: TypeTokens,
+		}
+		for i := 0; i < amount; i++ {
+			token, err := c.Tokens.Generate(c.User.Owner())
+			if err != nil {
+				log.Printf("failed to generate token: %s", err)
+				return
+			}
+			reply.Tokens = append(reply.Tokens, token)
+			reply.Hosts = append(reply.Hosts, c.Tokens.Host(token))
+		}
+		c.Subscription.Add(reply.Tokens, nil)
+		c.reply(reply)
+	case TypeSubscribe, TypeUnsubscribe:
+		if message.Type == TypeSubscribe {
+			c.Subscription.Add(message.Tokens, message.Suffixes)
+		} else {
+			c.Subscription.Remove(message.Tokens, message.Suffixes)
 		}
+		reply := &ServerMessage{
+			Type: TypeSubscriptions,
+		}
+		reply.Tokens, reply.Suffixes = c.Subscription.List()
+		c.reply(reply)
+	case TypeReplay:
+		select {
+		case c.Replays <- ohren.Query{
+			Since:   message.Since,
+			AfterID: message.AfterID,
+			Owner:   c.User.Owner(),
+		}:
+		case <-c.done:
+		}
+	default:
+		log.Printf("unknown client message type: %d", message.Type)
 	}
 }
 
-type jsonDetails struct {
-	Type          string    `json:"type"`
-	Description   string    `json:"description"`
-	Hosts         []string  `json:"hosts"`
-	StartTime     time.Time `json:"start_time"`
-	RemoteAddress string    `json:"client_address"`
-	RemotePort    int       `json:"client_port"`
-	LocalAddress  string    `json:"local_address"`
-	LocalPort     int       `json:"local_port"`
+// reply passes the message to the write pump unless it stopped.
+func (c *WebsocketClient) reply(message *ServerMessage) {
+	select {
+	case c.Replies <- message:
+	case <-c.done:
+	}
 }
 
-func (c WebsocketClient) writePump() {
+// writePump writes the messages of the client. Send is only closed by the hub,
+// closing the connection stops the read pump which unregisters the client.
+func (c *WebsocketClient) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer func() {
 		ticker.Stop()
-		close(c.Send)
+		close(c.done)
 		c.Connection.Close()
 	}()
 	for {
@@ -87,25 +156,23 @@ func (c WebsocketClient) writePump() {
 				_ = c.Connection.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
-			details := message.Details
-			if details != nil {
-				jsonOutput := &jsonDetails{
-					RemoteAddress: message.RemoteAddress,
-					RemotePort:    message.RemotePort,
-					LocalPort:     message.LocalPort,
-					LocalAddress:  message.LocalAddress,
-					Type:          string(details.Type()),
-					Description:   details.Describe(),
-					Hosts:         details.Hosts(),
-					StartTime:     message.StartTime,
-				}
-				err = c.Connection.WriteJSON(jsonOutput)
-				if err != nil {
-					return
-				}
-			} else {
-				log.Println("details are nil")
-				continue
+			err = c.Connection.WriteJSON(message)
+			if err != nil {
+				return
+			}
+
+		case query := <-c.Replays:
+			if err := c.replay(query); err != nil {
+				log.Printf("replay failed: %s", err)
+				return
+			}
+
+		case reply := <-c.Replies:
+			if err := c.Connection.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+				return
+			}
+			if err := c.Connection.WriteJSON(reply); err != nil {
+				return
 			}
 
 		case <-ticker.C:
@@ -119,16 +186,52 @@ func (c WebsocketClient) writePump() {
 	}
 }
 
+// replay writes all stored interactions matching the query and the subscription
+// followed by a TypeReplayed message. Interactions broadcast meanwhile are
+// buffered in Send.
+func (c *WebsocketClient) replay(query ohren.Query) error {
+	interactions, err := c.Store.Find(query)
+	if err != nil {
+		return err
+	}
+	reply := &ServerMessage{
+		Type: TypeReplayed,
+	}
+	for _, interaction := range interactions {
+		if !c.accepts(interaction) {
+			continue
+		}
+		if err := c.Connection.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+			return err
+		}
+		if err := c.Connection.WriteJSON(interaction); err != nil {
+			return err
+		}
+		reply.Count++
+	}
+	if err := c.Connection.SetWriteDeadline(time.Now().Add(writeWait)); err != nil {
+		return err
+	}
+	return c.Connection.WriteJSON(reply)
+}
+
 type websocketHandler struct {
 	newClients    chan *WebsocketClient
 	closedClients chan *WebsocketClient
 	broadcast     chan ohren.Record
 	clients       map[*WebsocketClient]bool
+	tokens        *ohren.TokenRegistry
+	store         ohren.Store
+	// CheckOrigin decides whether the upgrade is allowed. If nil, only requests
+	// from the same host are allowed.
+	CheckOrigin func(r *http.Request) bool
 }
 
-func NewWebsocketHandler(broadcast chan ohren.Record) *websocketHandler {
+func NewWebsocketHandler(broadcast chan ohren.Record, tokens *ohren.TokenRegistry, store ohren.Store) *websocketHandler {
 	handler := new(websocketHandler)
 	handler.broadcast = broadcast
+	handler.tokens = tokens
+	handler.store = store
 	handler.newClients = make(chan *WebsocketClient)
 	handler.closedClients = make(chan *WebsocketClient)
 	handler.clients = make(map[*WebsocketClient]bool)
@@ -136,21 +239,38 @@ func NewWebsocketHandler(broadcast chan ohren.Record) *websocketHandler {
 }
 
 func (ws websocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var upgrader = websocket.Upgrader{}
+	user := auth.UserFromRequest(r)
+	if user == nil {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var upgrader = websocket.Upgrader{
+		CheckOrigin: ws.CheckOrigin,
+	}
 	c, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade:", err)
 		return
 	}
 	client := new(WebsocketClient)
-	client.Send = make(chan ohren.Record)
+	client.Send = make(chan *ohren.Interaction, sendBufferSize)
+	client.Replies = make(chan *ServerMessage, 1)
+	client.Replays = make(chan ohren.Query, 1)
+	client.Tokens = ws.tokens
+	client.Store = ws.store
+	client.Subscription = NewSubscription()
+	client.User = user
 	client.Connection = c
 	client.OnClose = ws.unregisterClient
+	client.done = make(chan struct{})
 	ws.newClients <- client
 	go client.writePump()
 	go client.readPump()
 }
 
+// RunBroadcast registers clients and sends them the broadcast interactions. The
+// Send channel of a client is only closed here, when the client is unregistered
+// or when it can't keep up.
 func (ws websocketHandler) RunBroadcast() {
 	for {
 		select {
@@ -159,15 +279,24 @@ func (ws websocketHandler) RunBroadcast() {
 		case client := <-ws.closedClients:
 			if _, ok := ws.clients[client]; ok {
 				delete(ws.clients, client)
+				close(client.Send)
 			}
 		case message, ok := <-ws.broadcast:
 			if !ok {
 				log.Println("broadcast channel was closed")
 				break
 			}
+			interaction := ohren.NewInteraction(message)
+			if interaction == nil {
+				log.Println("details are nil")
+				continue
+			}
 			for client := range ws.clients {
+				if !client.accepts(interaction) {
+					continue
+				}
 				select {
-				case client.Send <- message:
+				case client.Send <- interaction:
 				default:
 					close(client.Send)
 					delete(ws.clients, client)
@@ -0,0 +1,145 @@
+package websocket
+
+import (
+	"encoding/json"
+	"github.com/coffeemakr/ohren"
+	"github.com/coffeemakr/ohren/auth"
+	"github.com/gorilla/websocket"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testDetails string
+
+func (d testDetails) Type() ohren.RequestType { return ohren.RequestTypeDNS }
+func (d testDetails) Describe() string        { return string(d) }
+func (d testDetails) Hosts() []string         { return []string{string(d)} }
+
+// newTestServer serves the websocket handler to the administrator with the API
+// key "admin" and the user red with the API key "red".
+func newTestServer(t *testing.T, store ohren.Store) (*httptest.Server, chan ohren.Record) {
+	broadcast := make(chan ohren.Record)
+	handler := NewWebsocketHandler(broadcast, ohren.NewTokenRegistry("example.com"), store)
+	go handler.RunBroadcast()
+	authenticator := auth.NewAuthenticator([]string{"admin"})
+	authenticator.AddUser("red", "", []string{"red"})
+	server := httptest.NewServer(authenticator.Require(handler))
+	t.Cleanup(server.Close)
+	return server, broadcast
+}
+
+// dial connects with the API key and waits until the client subscribed to the suffix.
+func dial(t *testing.T, server *httptest.Server, key string, suffix string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/?api_key=" + key
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.WriteJSON(&ClientMessage{Type: TypeSubscribe, Suffixes: []string{suffix}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, reply := readMessage(t, conn); reply == nil || reply.Type != TypeSubscriptions {
+		t.Fatalf("subscription answered with %+v", reply)
+	}
+	return conn
+}
+
+// readMessage returns the next interaction or server message.
+func readMessage(t *testing.T, conn *websocket.Conn) (*ohren.Interaction, *ServerMessage) {
+	t.Helper()
+	_, message, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(message, &fields); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fields["id"]; ok {
+		interaction := new(ohren.Interaction)
+		if err := json.Unmarshal(message, interaction); err != nil {
+			t.Fatal(err)
+		}
+		return interaction, nil
+	}
+	reply := new(ServerMessage)
+	if err := json.Unmarshal(message, reply); err != nil {
+		t.Fatal(err)
+	}
+	return nil, reply
+}
+
+func TestBroadcastOwners(t *testing.T) {
+	server, broadcast := newTestServer(t, ohren.NewMemoryStore())
+	admin := dial(t, server, "admin", "example.com")
+	red := dial(t, server, "red", "example.com")
+	for i, owner := range []string{"blue", "", "red"} {
+		broadcast <- &ohren.RecordedConnection{
+			ID:      uint64(i + 1),
+			Owner:   owner,
+			Details: testDetails("a.example.com"),
+		}
+	}
+	// foreign records would be sent before the own record
+	if interaction, _ := readMessage(t, red); interaction == nil || interaction.ID != 3 {
+		t.Errorf("user received %+v instead of the own record", interaction)
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if interaction, _ := readMessage(t, admin); interaction == nil || interaction.ID != id {
+			t.Errorf("administrator received %+v instead of record %d", interaction, id)
+		}
+	}
+}
+
+func TestReplayOwners(t *testing.T) {
+	store := ohren.NewMemoryStore()
+	for _, owner := range []string{"blue", "red", "", "red"} {
+		if err := store.Add(&ohren.Interaction{Owner: owner, Hosts: []string{"a.example.com"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		name string
+		key  string
+		ids  []uint64
+	}{
+		{name: "admin", key: "admin", ids: []uint64{1, 2, 3, 4}},
+		{name: "user", key: "red", ids: []uint64{2, 4}},
+	}
+	server, _ := newTestServer(t, store)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn := dial(t, server, test.key, "example.com")
+			if err := conn.WriteJSON(&ClientMessage{Type: TypeReplay}); err != nil {
+				t.Fatal(err)
+			}
+			var ids []uint64
+			for {
+				interaction, reply := readMessage(t, conn)
+				if reply != nil {
+					if reply.Type != TypeReplayed || reply.Count != len(test.ids) {
+						t.Errorf("replay finished with %+v", reply)
+					}
+					break
+				}
+				ids = append(ids, interaction.ID)
+			}
+			if len(ids) != len(test.ids) {
+				t.Fatalf("replayed %v, want %v", ids, test.ids)
+			}
+			for i, id := range ids {
+				if id != test.ids[i] {
+					t.Errorf("replayed %v, want %v", ids, test.ids)
+					break
+				}
+			}
+		})
+	}
+}